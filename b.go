@@ -1,7 +1,6 @@
 package building
 
 import (
-	"bytes"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -32,9 +31,7 @@ func Main() {
 
 func build(b *B, dir string) {
 	g := b.Go()
-	buf := &bytes.Buffer{}
-	g.WithOutput(buf).Run("list")
-	path := strings.TrimSpace(buf.String())
+	path, _ := g.Output("list")
 	// $$$$ MAT: parse recursively?
 	mainCode, pkgCode, isMain, err := parse(dir, path)
 	if err != nil {
@@ -11,11 +11,18 @@ import (
 
 type B struct {
 	root          string
+	module        bool
+	moduleDir     string
 	targets       map[string]target
 	defaultTarget *target
 	tools         map[string]Tool
+	detections    map[string]*detector
 	helpers       map[string]bool
 	mutex         sync.Mutex
+	images        map[string]string
+	imagesMutex   sync.Mutex
+	services      []*Service
+	env           []string
 }
 
 type target struct {
@@ -26,14 +33,34 @@ type target struct {
 
 var b *B
 
+// Init creates the builder of the project, using pkgName as root package
+// unless a go.mod declares it.
 func Init(pkgName string) *B {
 	if b != nil {
 		panic("build.Init(...) called twice")
 	}
+	module, dir, err := findModule(".")
+	if err != nil {
+		b.Fatalln(err)
+	}
+	root := pkgName
+	if module != "" {
+		root = module
+	}
 	b = &B{
-		root:    pkgName,
-		targets: make(map[string]target),
-		tools:   make(map[string]Tool),
+		root:       root,
+		module:     module != "",
+		moduleDir:  dir,
+		targets:    make(map[string]target),
+		tools:      make(map[string]Tool),
+		detections: make(map[string]*detector),
+		images:     make(map[string]string),
+	}
+	for _, t := range []target{
+		{name: "save-images", description: "exports the tool images into an archive", f: (*B).SaveImages},
+		{name: "load-images", description: "imports the tool images from an archive", f: (*B).LoadImages},
+	} {
+		b.targets[t.name] = t
 	}
 	return b
 }
@@ -61,7 +88,9 @@ func (b *B) MakeTarget(name, description string, f func(*B)) target {
 func (b *B) Build(t target) {
 	b.Println(">", t.name)
 	start := time.Now()
+	n := b.countServices()
 	t.f(b)
+	b.stopServices(n)
 	delta := time.Now().Sub(start)
 	b.Printf("< %s (took %s)", t.name, delta)
 }
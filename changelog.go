@@ -0,0 +1,172 @@
+package building
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Changelog wraps generating a Markdown changelog from Conventional Commits,
+// see https://www.conventionalcommits.org.
+type Changelog struct {
+	from  string
+	to    string
+	repo  string
+	url   string
+	title string
+}
+
+// Changelog handles the changes between two references, from the first
+// commit if from is empty and up to HEAD if to is empty.
+func (b *B) Changelog(from, to string) Changelog {
+	return Changelog{
+		from: from,
+		to:   to,
+	}
+}
+
+// WithURL links pull requests numbers to the repository at url, for
+// instance https://github.com/mat007/brique.
+func (c Changelog) WithURL(url string) Changelog {
+	c.url = strings.TrimSuffix(url, "/")
+	return c
+}
+
+// WithTitle sets the title, defaults to the to reference or to Unreleased if
+// there is none.
+func (c Changelog) WithTitle(title string) Changelog {
+	c.title = title
+	return c
+}
+
+// WithRepo reads the commits of the repository in dir instead of the
+// project one.
+func (c Changelog) WithRepo(dir string) Changelog {
+	c.repo = dir
+	return c
+}
+
+// String returns the changelog.
+func (c Changelog) String() string {
+	args := []string{"log", "--no-merges", "--format=%H%x1f%s%x1f%b%x1e"}
+	if c.repo != "" {
+		args = append([]string{"-C", c.repo}, args...)
+	}
+	to := c.to
+	if to == "" {
+		to = "HEAD"
+	}
+	if c.from != "" {
+		to = c.from + ".." + to
+	}
+	out, code := b.Git().WithSuccess().Output(append(args, to)...)
+	if code != 0 {
+		b.Fatalln("failed to read the commits of", to)
+	}
+	title := c.title
+	if title == "" {
+		title = c.to
+	}
+	if title == "" {
+		title = "Unreleased"
+	}
+	return c.render(title, parseCommits(out))
+}
+
+// Write writes the changelog into file.
+func (c Changelog) Write(file string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		b.Fatalln(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(c.String()), 0644); err != nil {
+		b.Fatalln(err)
+	}
+}
+
+type change struct {
+	hash        string
+	kind        string
+	scope       string
+	description string
+	breaking    bool
+}
+
+var conventional = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+
+// parseCommits parses the output of git log with fields separated with
+// \x1f and records with \x1e.
+func parseCommits(out string) []change {
+	var changes []change
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) < 2 {
+			continue
+		}
+		c := change{hash: fields[0], description: fields[1]}
+		if m := conventional.FindStringSubmatch(fields[1]); m != nil {
+			c.kind = strings.ToLower(m[1])
+			c.scope = m[2]
+			c.breaking = m[3] == "!"
+			c.description = m[4]
+		}
+		if len(fields) > 2 && (strings.Contains(fields[2], "BREAKING CHANGE:") || strings.Contains(fields[2], "BREAKING-CHANGE:")) {
+			c.breaking = true
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+var changeGroups = []struct {
+	title string
+	match func(change) bool
+}{
+	{"Breaking Changes", func(c change) bool { return c.breaking }},
+	{"Features", func(c change) bool { return c.kind == "feat" }},
+	{"Bug Fixes", func(c change) bool { return c.kind == "fix" }},
+	{"Performance Improvements", func(c change) bool { return c.kind == "perf" }},
+	{"Other Changes", func(c change) bool { return true }},
+}
+
+var pullRequest = regexp.MustCompile(`#(\d+)`)
+
+func (c Changelog) render(title string, changes []change) string {
+	s := ""
+	if title != "" {
+		s += "## " + title + "\n\n"
+	}
+	done := make([]bool, len(changes))
+	for _, g := range changeGroups {
+		entries := ""
+		for i, ch := range changes {
+			if done[i] || !g.match(ch) {
+				continue
+			}
+			done[i] = true
+			entries += c.entry(ch)
+		}
+		if entries != "" {
+			s += "### " + g.title + "\n\n" + entries + "\n"
+		}
+	}
+	return s
+}
+
+func (c Changelog) entry(ch change) string {
+	description := ch.description
+	if c.url != "" {
+		description = pullRequest.ReplaceAllString(description, fmt.Sprintf("[#$1](%s/pull/$1)", c.url))
+	}
+	s := "- "
+	if ch.scope != "" {
+		s += "**" + ch.scope + ":** "
+	}
+	hash := ch.hash
+	if len(hash) > 7 {
+		hash = hash[:7]
+	}
+	return s + description + " (" + hash + ")\n"
+}
@@ -0,0 +1,119 @@
+package building
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/assert"
+)
+
+// useBuilder sets a builder for the duration of a test running tools.
+func useBuilder() func() {
+	old := b
+	b = &B{
+		tools:      make(map[string]Tool),
+		detections: make(map[string]*detector),
+		images:     make(map[string]string),
+	}
+	return func() {
+		b = old
+	}
+}
+
+func TestParseCommits(t *testing.T) {
+	changes := parseCommits("1234567890\x1ffeat(api)!: remove Foo\x1f\x1e\n" +
+		"abcdef1234\x1ffix: crash (#12)\x1fBREAKING CHANGE: no more crash\n\x1e\n" +
+		"fedcba9876\x1fUpdate README\x1f\x1e\n")
+	assert.DeepEqual(t, changes, []change{
+		{hash: "1234567890", kind: "feat", scope: "api", description: "remove Foo", breaking: true},
+		{hash: "abcdef1234", kind: "fix", description: "crash (#12)", breaking: true},
+		{hash: "fedcba9876", description: "Update README"},
+	}, cmp.AllowUnexported(change{}))
+}
+
+func TestRenderChangelog(t *testing.T) {
+	changes := []change{
+		{hash: "1234567890", kind: "feat", scope: "api", description: "remove Foo", breaking: true},
+		{hash: "2234567890", kind: "feat", description: "add Bar (#3)"},
+		{hash: "3234567890", kind: "fix", scope: "git", description: "fix dirty detection"},
+		{hash: "4234567890", kind: "perf", description: "cache detections"},
+		{hash: "5234567890", kind: "chore", description: "update dependencies"},
+	}
+	assert.Equal(t, b.Changelog("v1.0.0", "v1.1.0").WithURL("https://github.com/mat007/brique/").render("v1.1.0", changes),
+		`## v1.1.0
+
+### Breaking Changes
+
+- **api:** remove Foo (1234567)
+
+### Features
+
+- add Bar ([#3](https://github.com/mat007/brique/pull/3)) (2234567)
+
+### Bug Fixes
+
+- **git:** fix dirty detection (3234567)
+
+### Performance Improvements
+
+- cache detections (4234567)
+
+### Other Changes
+
+- update dependencies (5234567)
+
+`)
+}
+
+func TestChangelogFromGit(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	defer useBuilder()()
+	git(t, dir.Path(), "commit", "-q", "--allow-empty", "-m", "feat(git): read tags (#7)")
+	git(t, dir.Path(), "commit", "-q", "--allow-empty", "-m", "fix: handle packs")
+
+	file := filepath.Join(dir.Path(), "out", "CHANGELOG.md")
+	b.Changelog("light", "").WithRepo(dir.Path()).WithURL("https://example.com/a").Write(file)
+	content, err := ioutil.ReadFile(file)
+	assert.NilError(t, err)
+	hash := git(t, dir.Path(), "rev-parse", "--short=7", "HEAD")
+	parent := git(t, dir.Path(), "rev-parse", "--short=7", "HEAD~1")
+	grandParent := git(t, dir.Path(), "rev-parse", "--short=7", "HEAD~2")
+	assert.Equal(t, string(content), `## Unreleased
+
+### Features
+
+- **git:** read tags ([#7](https://example.com/a/pull/7)) (`+parent+`)
+
+### Bug Fixes
+
+- handle packs (`+hash+`)
+
+### Other Changes
+
+- commit (`+grandParent+`)
+
+`)
+}
+
+func TestChangelogUnknownReference(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	defer useBuilder()()
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "failed to read the commits of missing..HEAD\n"), buf.String())
+	}()
+	_ = b.Changelog("missing", "").WithRepo(dir.Path()).String()
+}
+
+func TestChangelogEmpty(t *testing.T) {
+	assert.Equal(t, b.Changelog("", "v1.0.0").render("v1.0.0", nil), "## v1.0.0\n\n")
+}
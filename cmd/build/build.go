@@ -18,10 +18,15 @@ func All(b *building.B) {
 
 // Test runs the tests
 func Test(b *building.B) {
-	b.Go("test", "-test.run", *testRun, "./...")
+	b.GoTest("./...").WithRun(*testRun).Run()
 }
 
 // Depends retrieves the dependencies
 func Depends(b *building.B) {
 	b.Dep("ensure")
 }
+
+// Coverage checks the test coverage
+func Coverage(b *building.B) {
+	b.Coverage("./...").WithThreshold(50).Run()
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type Command struct {
@@ -13,7 +15,10 @@ type Command struct {
 	dir     string
 	env     []string
 	output  io.Writer
+	stderr  io.Writer
+	input   io.Reader
 	success bool
+	retry   retry
 }
 
 func (b *B) MakeCommand(name string, args ...string) Command {
@@ -48,6 +53,33 @@ func (c Command) WithOutput(w io.Writer) Command {
 	return c
 }
 
+// WithStderr redirects the error output, which then gets included in the
+// failure message if the command fails.
+func (c Command) WithStderr(w io.Writer) Command {
+	c.stderr = w
+	return c
+}
+
+func (c Command) WithInput(r io.Reader) Command {
+	c.input = r
+	return c
+}
+
+// WithRetry runs the command again up to n times while it fails, waiting for
+// backoff before the first retry and doubling it each time.
+// The input of the command is replayed, apart from the standard input.
+func (c Command) WithRetry(n int, backoff time.Duration) Command {
+	c.retry.attempts = n
+	c.retry.backoff = backoff
+	return c
+}
+
+// WithRetryOn only retries if the error output of the command matches pattern.
+func (c Command) WithRetryOn(pattern string) Command {
+	c.retry.pattern = regexp.MustCompile(pattern)
+	return c
+}
+
 func (c Command) WithSuccess() Command {
 	c.success = true
 	return c
@@ -55,17 +87,65 @@ func (c Command) WithSuccess() Command {
 
 func (c Command) Run(args ...string) int {
 	b.Println("running", append([]string{c.name}, args...))
-	if c.output == nil {
-		c.output = os.Stdout
+	input := c.retry.replay(c.input)
+	return execute(func() *exec.Cmd {
+		c.input = input()
+		return c.command(args)
+	}, c.success, c.stderr, c.retry)
+}
+
+// Args makes a pipeline stage running the command with args, see Pipe.
+func (c Command) Args(args ...string) Stage {
+	return Stage{
+		name:    c.name,
+		success: c.success,
+		stderr:  c.stderr,
+		command: func() *exec.Cmd {
+			b.Println("running", append([]string{c.name}, args...))
+			return c.command(args)
+		},
 	}
+}
+
+// Start runs the command in the background, see Service.
+func (c Command) Start(args ...string) *Service {
+	b.Println("starting", append([]string{c.name}, args...))
+	return b.start(c.name, c.command(args), c.success, c.stderr, nil)
+}
+
+func (c Command) command(args []string) *exec.Cmd {
 	cmd := exec.Command(c.name, args...)
 	cmd.Dir = c.dir
-	cmd.Env = append(os.Environ(), c.env...)
-	cmd.Stderr = os.Stderr
+	cmd.Env = b.environ(c.env)
 	cmd.Stdout = c.output
-	code, err := run(cmd, c.success)
-	if err != nil {
-		b.Fatalln(err)
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if c.input != nil {
+		cmd.Stdin = c.input
+	}
+	return cmd
+}
+
+// Output runs the command and returns its trimmed output and exit code.
+// The error output is captured unless redirected with WithStderr.
+func (c Command) Output(args ...string) (string, int) {
+	return capture(c.stderr, func(stdout, stderr io.Writer) int {
+		return c.WithOutput(stdout).WithStderr(stderr).Run(args...)
+	})
+}
+
+// Lines runs the command and returns its non empty output lines and exit code.
+func (c Command) Lines(args ...string) ([]string, int) {
+	out, code := c.Output(args...)
+	return lines(out), code
+}
+
+// JSON runs the command and decodes its output into v if it succeeds.
+func (c Command) JSON(v interface{}, args ...string) int {
+	out, code := c.Output(args...)
+	if code == 0 {
+		decode(out, v)
 	}
 	return code
 }
@@ -0,0 +1,135 @@
+package building
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// helper returns a command re-executing the test binary to run
+// TestHelperProcess in a portable way.
+func helper() Command {
+	return Command{
+		name: os.Args[0],
+		env:  []string{"BRIQUE_HELPER_PROCESS=1"},
+	}
+}
+
+// captureLog sends the log output to w with the secrets masked, and returns
+// a function restoring the output set by init.
+func captureLog(w io.Writer) func() {
+	log.SetOutput(masker{w: w})
+	return func() {
+		log.SetOutput(masker{w: os.Stderr})
+	}
+}
+
+func helperArgs(args ...string) []string {
+	return append([]string{"-test.run=TestHelperProcess", "--"}, args...)
+}
+
+// TestHelperProcess echoes its arguments to stdout, or to stderr after
+// "-stderr", copies its input with "-cat", sleeps for the duration following
+// "-sleep", fails the n first times it is called with "-flaky file n" and
+// exits with the code following "-exit".
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("BRIQUE_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	w := os.Stdout
+	code := 0
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-stderr":
+			w = os.Stderr
+		case "-cat":
+			io.Copy(w, os.Stdin)
+		case "-yes":
+			for {
+				fmt.Fprintln(w, "y")
+			}
+		case "-head":
+			line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			fmt.Fprint(w, line)
+		case "-sleep":
+			i++
+			d, _ := time.ParseDuration(args[i])
+			time.Sleep(d)
+		case "-flaky":
+			content, _ := ioutil.ReadFile(args[i+1])
+			content = append(content, 'x')
+			ioutil.WriteFile(args[i+1], content, 0644)
+			n, _ := strconv.Atoi(args[i+2])
+			i += 2
+			if len(content) <= n {
+				fmt.Fprintln(os.Stderr, "flaky", len(content))
+				code = len(content)
+			}
+		case "-exit":
+			i++
+			code, _ = strconv.Atoi(args[i])
+		default:
+			fmt.Fprintln(w, args[i])
+		}
+	}
+	os.Exit(code)
+}
+
+func TestCommandOutput(t *testing.T) {
+	out, code := helper().Output(helperArgs(" foo ", "-stderr", "bar")...)
+	assert.Equal(t, out, "foo")
+	assert.Equal(t, code, 0)
+}
+
+func TestCommandOutputWithSuccess(t *testing.T) {
+	out, code := helper().WithSuccess().Output(helperArgs("foo", "-exit", "3")...)
+	assert.Equal(t, out, "foo")
+	assert.Equal(t, code, 3)
+}
+
+func TestCommandLines(t *testing.T) {
+	lines, code := helper().Lines(helperArgs("foo", "", "bar")...)
+	assert.DeepEqual(t, lines, []string{"foo", "bar"})
+	assert.Equal(t, code, 0)
+}
+
+func TestCommandJSON(t *testing.T) {
+	var v struct {
+		Name string
+	}
+	code := helper().JSON(&v, helperArgs(`{"Name": "foo"}`)...)
+	assert.Equal(t, code, 0)
+	assert.Equal(t, v.Name, "foo")
+}
+
+func TestCommandStderr(t *testing.T) {
+	buf := &bytes.Buffer{}
+	code := helper().WithStderr(buf).Run(helperArgs("-stderr", "foo")...)
+	assert.Equal(t, code, 0)
+	assert.Equal(t, buf.String(), "foo\n")
+}
+
+func TestCommandFailureWithStderr(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "exit status 1: foo\n"), buf.String())
+	}()
+	helper().Output(helperArgs("-stderr", "foo", "-exit", "1")...)
+}
@@ -0,0 +1,113 @@
+package building
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// extractVersion returns the first dotted number found in output.
+func extractVersion(output string) string {
+	return versionPattern.FindString(output)
+}
+
+// comparison is a clause of a version constraint.
+type comparison struct {
+	op      string
+	version string
+}
+
+var comparisonPattern = regexp.MustCompile(`^(|=|==|!=|>|>=|<|<=)\s*(v?\d+(\.\d+)*)$`)
+
+// parseConstraint parses a comma separated list of comparisons, e.g.
+// ">=1.10,<1.12", checking every one of them.
+func parseConstraint(constraint string) ([]comparison, error) {
+	var comparisons []comparison
+	for _, c := range strings.Split(constraint, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		m := comparisonPattern.FindStringSubmatch(c)
+		if m == nil {
+			return nil, fmt.Errorf("invalid version constraint %q", c)
+		}
+		comparisons = append(comparisons, comparison{op: m[1], version: m[2]})
+	}
+	return comparisons, nil
+}
+
+// satisfies checks version against a constraint, see parseConstraint.
+// An empty constraint is always satisfied.
+func satisfies(version, constraint string) (bool, error) {
+	comparisons, err := parseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range comparisons {
+		cmp := compareVersions(version, c.version)
+		var ok bool
+		switch c.op {
+		case "", "=", "==":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compareVersions compares dotted numbers, missing parts counting as zero.
+func compareVersions(a, b string) int {
+	as := versionParts(a)
+	bs := versionParts(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, p := range strings.Split(strings.TrimPrefix(version, "v"), ".") {
+		end := strings.IndexFunc(p, func(r rune) bool { return r < '0' || r > '9' })
+		if end != -1 {
+			p = p[:end]
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if end != -1 {
+			break
+		}
+	}
+	return parts
+}
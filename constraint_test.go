@@ -0,0 +1,58 @@
+package building
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestExtractVersion(t *testing.T) {
+	assert.Equal(t, extractVersion("go version go1.10.3 linux/amd64"), "1.10.3")
+	assert.Equal(t, extractVersion("git version 2.17.1"), "2.17.1")
+	assert.Equal(t, extractVersion("jq-1.5-1-a5b5cbe"), "1.5")
+	assert.Equal(t, extractVersion("no version"), "")
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, compareVersions("1.10.3", "1.10.3"), 0)
+	assert.Equal(t, compareVersions("1.10", "1.10.0"), 0)
+	assert.Equal(t, compareVersions("1.9", "1.10"), -1)
+	assert.Equal(t, compareVersions("1.10.3", "1.10"), 1)
+	assert.Equal(t, compareVersions("v2.0.5", "2.0.4"), 1)
+	assert.Equal(t, compareVersions("1.11rc1", "1.11"), 0)
+}
+
+func TestSatisfies(t *testing.T) {
+	checkSatisfies(t, "1.10.3", "", true)
+	checkSatisfies(t, "1.10.3", ">=1.10,<1.12", true)
+	checkSatisfies(t, "1.11", ">=1.10, <1.12", true)
+	checkSatisfies(t, "1.9.7", ">=1.10,<1.12", false)
+	checkSatisfies(t, "1.12", ">=1.10,<1.12", false)
+	checkSatisfies(t, "1.10.3", "1.10.3", true)
+	checkSatisfies(t, "1.10.3", "=1.10.2", false)
+	checkSatisfies(t, "1.10.3", "!=1.10.2", true)
+	checkSatisfies(t, "1.10.3", ">1.10.3", false)
+	checkSatisfies(t, "1.10.3", "<=1.10.3", true)
+	checkSatisfies(t, "", ">=1.10", false)
+}
+
+func checkSatisfies(t *testing.T, version, constraint string, expected bool) {
+	t.Helper()
+	ok, err := satisfies(version, constraint)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, expected, "%s %s", version, constraint)
+}
+
+func TestInvalidConstraint(t *testing.T) {
+	_, err := satisfies("1.10", "~>1.10")
+	assert.Error(t, err, `invalid version constraint "~>1.10"`)
+	_, err = satisfies("1.10", ">=")
+	assert.Error(t, err, `invalid version constraint ">="`)
+	_, err = parseConstraint(">=1.10, <2.x")
+	assert.Error(t, err, `invalid version constraint "<2.x"`)
+	c, err := parseConstraint(">= 1.10, v2")
+	assert.NilError(t, err)
+	assert.Equal(t, len(c), 2)
+	assert.Equal(t, c[0], comparison{op: ">=", version: "1.10"})
+	assert.Equal(t, c[1], comparison{version: "v2"})
+}
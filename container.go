@@ -0,0 +1,255 @@
+package building
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	runtimeName = flag.String("runtime", "", "container runtime (docker, podman, nerdctl), defaults to $BRIQUE_RUNTIME or the first one found")
+
+	engineOnce sync.Once
+	engine     containerRuntime
+)
+
+func init() {
+	// Manual flags parsing to select the runtime before any image gets built
+	for i, arg := range os.Args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == "runtime" && i+1 < len(os.Args) {
+			*runtimeName = os.Args[i+1]
+			return
+		}
+		if strings.HasPrefix(arg, "runtime=") {
+			*runtimeName = strings.TrimPrefix(arg, "runtime=")
+			return
+		}
+	}
+}
+
+// containerRuntime describes the command line of an OCI container runtime.
+type containerRuntime struct {
+	name string
+	// stdin is true if the build context can be streamed as a tar archive,
+	// otherwise it must be provided as a folder.
+	stdin bool
+	// userns maps the user in the container, for instance so that files
+	// created in a mounted volume belong to the user with rootless runtimes.
+	userns string
+	// api is true if the runtime can be driven through the Docker Engine
+	// API rather than its command line.
+	api bool
+}
+
+var runtimes = []containerRuntime{
+	{name: "docker", stdin: true, api: true},
+	{name: "podman", userns: "keep-id"},
+	{name: "nerdctl"},
+}
+
+func containerEngine() containerRuntime {
+	engineOnce.Do(func() {
+		r, err := selectRuntime(*runtimeName, os.Getenv("BRIQUE_RUNTIME"), exec.LookPath)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		b.Debugln("using container runtime", r.name)
+		engine = r
+	})
+	return engine
+}
+
+func selectRuntime(flag, env string, lookPath func(string) (string, error)) (containerRuntime, error) {
+	name := flag
+	if name == "" {
+		name = env
+	}
+	if name != "" {
+		for _, r := range runtimes {
+			if r.name == name {
+				return r, nil
+			}
+		}
+		return containerRuntime{}, fmt.Errorf("unknown container runtime %q", name)
+	}
+	for _, r := range runtimes {
+		if _, err := lookPath(r.name); err == nil {
+			return r, nil
+		}
+	}
+	return runtimes[0], nil
+}
+
+// containerBuild describes an image to build.
+type containerBuild struct {
+	tags []string
+	// context is either a folder or "-" to read a tar archive from stdin
+	context string
+	// dockerfile is relative to the context, defaults to Dockerfile
+	dockerfile string
+	labels     []string
+	// args holds the build arguments as "name=value"
+	args   []string
+	target string
+	pull   bool
+}
+
+// buildArgs returns the arguments to build an image.
+func (r containerRuntime) buildArgs(c containerBuild) []string {
+	args := []string{"build"}
+	for _, t := range c.tags {
+		args = append(args, "-t", t)
+	}
+	if c.dockerfile != "" {
+		args = append(args, "-f", c.dockerfile)
+	}
+	for _, l := range c.labels {
+		args = append(args, "--label", l)
+	}
+	for _, a := range c.args {
+		args = append(args, "--build-arg", a)
+	}
+	if c.target != "" {
+		args = append(args, "--target", c.target)
+	}
+	if c.pull {
+		args = append(args, "--pull")
+	}
+	return append(args, c.context)
+}
+
+// inspectArgs returns the arguments to print a field of image using a Go
+// template.
+func (r containerRuntime) inspectArgs(image, format string) []string {
+	return []string{"image", "inspect", "--format", format, image}
+}
+
+// imagesArgs returns the arguments to list the images whose names start
+// with prefix.
+func (r containerRuntime) imagesArgs(prefix string) []string {
+	return []string{"images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", "reference=" + prefix + "*"}
+}
+
+// saveArgs returns the arguments to export images into archive.
+func (r containerRuntime) saveArgs(archive string, images []string) []string {
+	return append([]string{"save", "-o", archive}, images...)
+}
+
+// tagArgs returns the arguments to tag image as target.
+func (r containerRuntime) tagArgs(image, target string) []string {
+	return []string{"tag", image, target}
+}
+
+// pushArgs returns the arguments to push image to its registry.
+func (r containerRuntime) pushArgs(image string) []string {
+	return []string{"push", image}
+}
+
+// pullArgs returns the arguments to pull image from its registry.
+func (r containerRuntime) pullArgs(image string) []string {
+	return []string{"pull", image}
+}
+
+// loadArgs returns the arguments to import images from archive.
+func (r containerRuntime) loadArgs(archive string) []string {
+	return []string{"load", "-i", archive}
+}
+
+// containerRun describes a command to run in a container.
+type containerRun struct {
+	image string
+	// name of the container, generated by the runtime if empty
+	name string
+	// src is mounted as dst
+	src string
+	dst string
+	// workdir is the working directory, defaults to dst
+	workdir string
+	// env holds the names of the variables forwarded from the environment
+	// of the runtime command.
+	env []string
+	// ports published as "host:container"
+	ports []string
+	cmd   []string
+}
+
+// runArgs returns the arguments to run a command in a container.
+func (r containerRuntime) runArgs(c containerRun) []string {
+	args := []string{"run", "--rm", "-v", c.src + ":" + c.dst, "-w", c.dir(), "-i"}
+	if r.userns != "" {
+		args = append(args, "--userns="+r.userns)
+	}
+	if c.name != "" {
+		args = append(args, "--name", c.name)
+	}
+	for _, p := range c.ports {
+		args = append(args, "-p", p)
+	}
+	for _, e := range c.env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, c.image)
+	return append(args, c.cmd...)
+}
+
+func (c containerRun) dir() string {
+	if c.workdir == "" {
+		return c.dst
+	}
+	return c.workdir
+}
+
+// removeArgs returns the arguments to forcibly remove a container.
+func (r containerRuntime) removeArgs(name string) []string {
+	return []string{"rm", "-f", name}
+}
+
+// engine returns a client of the Docker Engine API if the runtime supports
+// it and the daemon can be reached, nil otherwise.
+func (r containerRuntime) engine() *dockerAPI {
+	if !r.api {
+		return nil
+	}
+	return docker()
+}
+
+// imageID returns the id of image or an empty string if it does not exist.
+func (r containerRuntime) imageID(image string) string {
+	if api := r.engine(); api != nil {
+		i, err := api.inspect(image)
+		if err != nil && !isNotFound(err) {
+			b.Debugln(err)
+		}
+		return i.ID
+	}
+	return r.inspect(image, "{{.Id}}")
+}
+
+// imageLabel returns a label of image or an empty string if it does not
+// exist.
+func (r containerRuntime) imageLabel(image, label string) string {
+	if api := r.engine(); api != nil {
+		i, err := api.inspect(image)
+		if err != nil && !isNotFound(err) {
+			b.Debugln(err)
+		}
+		return i.Config.Labels[label]
+	}
+	return r.inspect(image, `{{index .Config.Labels "`+label+`"}}`)
+}
+
+// inspect returns a field of image or an empty string if it does not exist.
+func (r containerRuntime) inspect(image, format string) string {
+	out, err := exec.Command(r.name, r.inspectArgs(image, format)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,130 @@
+package building
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestSelectRuntime(t *testing.T) {
+	checkSelectRuntime(t, "", "", []string{"docker", "podman"}, "docker")
+	checkSelectRuntime(t, "", "", []string{"podman"}, "podman")
+	checkSelectRuntime(t, "", "", []string{"nerdctl"}, "nerdctl")
+	checkSelectRuntime(t, "", "", nil, "docker")
+	checkSelectRuntime(t, "", "podman", []string{"docker"}, "podman")
+	checkSelectRuntime(t, "nerdctl", "podman", []string{"docker"}, "nerdctl")
+}
+
+func checkSelectRuntime(t *testing.T, flag, env string, installed []string, expected string) {
+	t.Helper()
+	r, err := selectRuntime(flag, env, func(name string) (string, error) {
+		for _, i := range installed {
+			if i == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", errors.New("not found")
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, r.name, expected)
+}
+
+func TestSelectUnknownRuntime(t *testing.T) {
+	_, err := selectRuntime("rkt", "", nil)
+	assert.Error(t, err, `unknown container runtime "rkt"`)
+}
+
+func TestRuntimeBuildArgs(t *testing.T) {
+	r, err := selectRuntime("docker", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.buildArgs(containerBuild{tags: []string{"image"}, context: "-"}),
+		[]string{"build", "-t", "image", "-"})
+	assert.DeepEqual(t, r.buildArgs(containerBuild{
+		tags:    []string{"image"},
+		context: "-",
+		labels:  []string{"brique.hash=1234"},
+		pull:    true,
+	}), []string{"build", "-t", "image", "--label", "brique.hash=1234", "--pull", "-"})
+	assert.DeepEqual(t, r.buildArgs(containerBuild{
+		tags:       []string{"app:1.0.0", "app:latest"},
+		context:    "-",
+		dockerfile: "build/Dockerfile",
+		args:       []string{"GO_VERSION=1.11"},
+		target:     "release",
+	}), []string{"build", "-t", "app:1.0.0", "-t", "app:latest", "-f", "build/Dockerfile",
+		"--build-arg", "GO_VERSION=1.11", "--target", "release", "-"})
+	r, err = selectRuntime("podman", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.buildArgs(containerBuild{
+		tags:    []string{"image"},
+		context: "/tmp/context",
+		labels:  []string{"brique.hash=1234"},
+	}),
+		[]string{"build", "-t", "image", "--label", "brique.hash=1234", "/tmp/context"})
+}
+
+func TestRuntimeInspectArgs(t *testing.T) {
+	r, err := selectRuntime("podman", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.inspectArgs("image", "{{.Id}}"),
+		[]string{"image", "inspect", "--format", "{{.Id}}", "image"})
+}
+
+func TestRuntimeRunArgs(t *testing.T) {
+	r, err := selectRuntime("docker", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.runArgs(containerRun{
+		image: "image",
+		src:   "/src",
+		dst:   "/go/src/pkg",
+		env:   []string{"A", "B"},
+		cmd:   []string{"go", "version"},
+	}), []string{"run", "--rm", "-v", "/src:/go/src/pkg", "-w", "/go/src/pkg", "-i",
+		"-e", "A", "-e", "B", "image", "go", "version"})
+	assert.DeepEqual(t, r.runArgs(containerRun{
+		image: "image",
+		name:  "brique-1234",
+		src:   "/src",
+		dst:   "/go/src/pkg",
+		ports: []string{"5432:5432"},
+		cmd:   []string{"postgres"},
+	}), []string{"run", "--rm", "-v", "/src:/go/src/pkg", "-w", "/go/src/pkg", "-i",
+		"--name", "brique-1234", "-p", "5432:5432", "image", "postgres"})
+	assert.DeepEqual(t, r.removeArgs("brique-1234"), []string{"rm", "-f", "brique-1234"})
+	r, err = selectRuntime("podman", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.runArgs(containerRun{
+		image: "image",
+		src:   "/src",
+		dst:   "/go/src/pkg",
+		cmd:   []string{"go", "version"},
+	}), []string{"run", "--rm", "-v", "/src:/go/src/pkg", "-w", "/go/src/pkg", "-i",
+		"--userns=keep-id", "image", "go", "version"})
+	r, err = selectRuntime("nerdctl", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.runArgs(containerRun{
+		image: "image",
+		src:   "/src",
+		dst:   "/go/src/pkg",
+		cmd:   []string{"go", "version"},
+	}), []string{"run", "--rm", "-v", "/src:/go/src/pkg", "-w", "/go/src/pkg", "-i",
+		"image", "go", "version"})
+}
+
+func TestRuntimeImagesArgs(t *testing.T) {
+	r, err := selectRuntime("docker", "", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.imagesArgs(imagePrefix("github.com/mat007/brique")),
+		[]string{"images", "--format", "{{.Repository}}:{{.Tag}}", "--filter", "reference=github.com-mat007-brique-build-*"})
+	assert.DeepEqual(t, r.saveArgs("images.tar", []string{"a:latest", "b:latest"}),
+		[]string{"save", "-o", "images.tar", "a:latest", "b:latest"})
+	assert.DeepEqual(t, r.pullArgs("alpine:3.8"),
+		[]string{"pull", "alpine:3.8"})
+	assert.DeepEqual(t, r.loadArgs("images.tar"),
+		[]string{"load", "-i", "images.tar"})
+	assert.DeepEqual(t, r.tagArgs("app:1.0.0", "localhost:5000/app:1.0.0"),
+		[]string{"tag", "app:1.0.0", "localhost:5000/app:1.0.0"})
+	assert.DeepEqual(t, r.pushArgs("localhost:5000/app:1.0.0"),
+		[]string{"push", "localhost:5000/app:1.0.0"})
+}
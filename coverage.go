@@ -0,0 +1,289 @@
+package building
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Coverage wraps collecting the test coverage of Go packages and checking
+// it against thresholds.
+type Coverage struct {
+	pkgs     []string
+	output   string
+	total    float64
+	minimum  float64
+	packages map[string]float64
+	flags    []string
+	summary  io.Writer
+}
+
+// Coverage handles the test coverage of Go packages, defaulting to "./...".
+func (b *B) Coverage(pkgs ...string) Coverage {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return Coverage{
+		pkgs:     pkgs,
+		output:   "coverage",
+		packages: map[string]float64{},
+	}
+}
+
+// WithOutput sets the folder receiving coverage.out and coverage.html,
+// defaults to "coverage".
+func (c Coverage) WithOutput(dir string) Coverage {
+	c.output = dir
+	return c
+}
+
+// WithThreshold fails if the total coverage is below percent.
+func (c Coverage) WithThreshold(percent float64) Coverage {
+	c.total = percent
+	return c
+}
+
+// WithPackageThreshold fails if the coverage of any package is below percent.
+func (c Coverage) WithPackageThreshold(percent float64) Coverage {
+	c.minimum = percent
+	return c
+}
+
+// WithPackage fails if the coverage of pkg is below percent, overriding the
+// package threshold.
+func (c Coverage) WithPackage(pkg string, percent float64) Coverage {
+	packages := map[string]float64{pkg: percent}
+	for p, v := range c.packages {
+		if p != pkg {
+			packages[p] = v
+		}
+	}
+	c.packages = packages
+	return c
+}
+
+// WithFlags adds flags to go test.
+func (c Coverage) WithFlags(flags ...string) Coverage {
+	c.flags = append(c.flags, flags...)
+	return c
+}
+
+// WithSummary sets where the summary gets written, defaults to stdout.
+func (c Coverage) WithSummary(w io.Writer) Coverage {
+	c.summary = w
+	return c
+}
+
+// Run runs the tests, writes the reports and returns the total coverage.
+func (c Coverage) Run() float64 {
+	if c.summary == nil {
+		c.summary = os.Stdout
+	}
+	// the profiles are written in the working directory to be reachable
+	// from a container
+	dir, err := ioutil.TempDir(".", ".coverage")
+	if err != nil {
+		b.Fatalln(err)
+	}
+	defer b.Remove(dir)
+	dir = filepath.ToSlash(dir)
+
+	pkgs, _ := b.Go().Lines(append([]string{"list"}, c.pkgs...)...)
+	var profiles []io.Reader
+	for i, pkg := range pkgs {
+		profile := fmt.Sprintf("%s/%d.out", dir, i)
+		// instrumenting all the packages accounts for the ones without
+		// tests, which would be left out otherwise
+		args := append([]string{"test", "-covermode=atomic", "-coverpkg=" + strings.Join(pkgs, ","), "-coverprofile=" + profile}, c.flags...)
+		b.Go().Run(append(args, pkg)...)
+		content, err := ioutil.ReadFile(profile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			b.Fatalln(err)
+		}
+		profiles = append(profiles, bytes.NewReader(content))
+	}
+	p, err := mergeProfiles(profiles...)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	merged := dir + "/coverage.out"
+	if err := ioutil.WriteFile(merged, []byte(p.String()), 0644); err != nil {
+		b.Fatalln(err)
+	}
+	html := dir + "/coverage.html"
+	b.Go().Run("tool", "cover", "-html="+merged, "-o", html)
+	b.Copy(c.output+"/", merged, html)
+
+	total, packages := p.coverage()
+	c.print(total, packages)
+	if err := c.check(total, packages); err != nil {
+		b.Fatalln(err)
+	}
+	return total
+}
+
+func (c Coverage) print(total float64, packages map[string]float64) {
+	w := tabwriter.NewWriter(c.summary, 0, 8, 2, ' ', 0)
+	for _, pkg := range sortedKeys(packages) {
+		fmt.Fprintf(w, "%s\t%.1f%%\n", pkg, packages[pkg])
+	}
+	fmt.Fprintf(w, "total\t%.1f%%\n", total)
+	b.Check(w.Flush())
+}
+
+func (c Coverage) check(total float64, packages map[string]float64) error {
+	var errs []string
+	if total < c.total {
+		errs = append(errs, fmt.Sprintf("total coverage %.1f%% is below %.1f%%", total, c.total))
+	}
+	for _, pkg := range sortedKeys(packages) {
+		threshold, ok := c.packages[pkg]
+		if !ok {
+			threshold = c.minimum
+		}
+		if packages[pkg] < threshold {
+			errs = append(errs, fmt.Sprintf("coverage of %s %.1f%% is below %.1f%%", pkg, packages[pkg], threshold))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// profile is a coverage profile as written by go test -coverprofile.
+type profile struct {
+	mode   string
+	blocks map[string]*block
+	order  []string
+}
+
+// block is a code block, identified by file:start,end in a profile.
+type block struct {
+	statements int
+	count      int
+}
+
+// mergeProfiles merges coverage profiles, adding up the counts of blocks
+// found in several profiles.
+func mergeProfiles(readers ...io.Reader) (*profile, error) {
+	p := &profile{
+		blocks: map[string]*block{},
+	}
+	for _, r := range readers {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "mode: ") {
+				mode := strings.TrimPrefix(line, "mode: ")
+				if p.mode != "" && p.mode != mode {
+					return nil, fmt.Errorf("cannot merge coverage profiles with modes %s and %s", p.mode, mode)
+				}
+				p.mode = mode
+				continue
+			}
+			if err := p.add(line); err != nil {
+				return nil, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *profile) add(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid coverage profile line %q", line)
+	}
+	statements, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid coverage profile line %q", line)
+	}
+	count, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fmt.Errorf("invalid coverage profile line %q", line)
+	}
+	existing, ok := p.blocks[fields[0]]
+	if !ok {
+		p.blocks[fields[0]] = &block{statements: statements, count: count}
+		p.order = append(p.order, fields[0])
+		return nil
+	}
+	if p.mode == "set" {
+		if count > existing.count {
+			existing.count = count
+		}
+	} else {
+		existing.count += count
+	}
+	return nil
+}
+
+func (p *profile) String() string {
+	mode := p.mode
+	if mode == "" {
+		mode = "set"
+	}
+	s := "mode: " + mode + "\n"
+	for _, id := range p.order {
+		blk := p.blocks[id]
+		s += fmt.Sprintf("%s %d %d\n", id, blk.statements, blk.count)
+	}
+	return s
+}
+
+// coverage returns the percentage of statements covered in total and by
+// package.
+func (p *profile) coverage() (float64, map[string]float64) {
+	covered := map[string]int{}
+	statements := map[string]int{}
+	var totalCovered, total int
+	for id, blk := range p.blocks {
+		pkg := path.Dir(id[:strings.LastIndex(id, ":")])
+		statements[pkg] += blk.statements
+		total += blk.statements
+		if blk.count > 0 {
+			covered[pkg] += blk.statements
+			totalCovered += blk.statements
+		}
+	}
+	packages := map[string]float64{}
+	for pkg, n := range statements {
+		packages[pkg] = percent(covered[pkg], n)
+	}
+	return percent(totalCovered, total), packages
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(n) * 100 / float64(total)
+}
@@ -0,0 +1,68 @@
+package building
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestMergeProfiles(t *testing.T) {
+	p, err := mergeProfiles(strings.NewReader(`mode: atomic
+example.com/a/a.go:3.10,5.2 2 1
+example.com/a/a.go:7.10,9.2 1 0
+`), strings.NewReader(`mode: atomic
+example.com/a/a.go:3.10,5.2 2 3
+example.com/b/b.go:3.10,5.2 4 0
+`))
+	assert.NilError(t, err)
+	assert.Equal(t, p.String(), `mode: atomic
+example.com/a/a.go:3.10,5.2 2 4
+example.com/a/a.go:7.10,9.2 1 0
+example.com/b/b.go:3.10,5.2 4 0
+`)
+	total, packages := p.coverage()
+	assert.Equal(t, total, float64(2)*100/7)
+	assert.DeepEqual(t, packages, map[string]float64{
+		"example.com/a": float64(2) * 100 / 3,
+		"example.com/b": 0,
+	})
+}
+
+func TestMergeProfilesWithDifferentModes(t *testing.T) {
+	_, err := mergeProfiles(strings.NewReader("mode: set\n"), strings.NewReader("mode: count\n"))
+	assert.Error(t, err, "cannot merge coverage profiles with modes set and count")
+}
+
+func TestMergeInvalidProfile(t *testing.T) {
+	_, err := mergeProfiles(strings.NewReader("mode: set\nexample.com/a/a.go:3.10,5.2 x 1\n"))
+	assert.Error(t, err, `invalid coverage profile line "example.com/a/a.go:3.10,5.2 x 1"`)
+}
+
+func TestCoverageSummary(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b.Coverage().WithSummary(buf).print(62.5, map[string]float64{
+		"example.com/b":     25,
+		"example.com/a/sub": 100,
+	})
+	assert.Equal(t, buf.String(), `example.com/a/sub  100.0%
+example.com/b      25.0%
+total              62.5%
+`)
+}
+
+func TestCoverageThresholds(t *testing.T) {
+	packages := map[string]float64{
+		"example.com/a": 80,
+		"example.com/b": 25,
+	}
+	c := b.Coverage()
+	assert.NilError(t, c.check(60, packages))
+	assert.NilError(t, c.WithThreshold(60).WithPackageThreshold(20).check(60, packages))
+	assert.Error(t, c.WithThreshold(70).check(60, packages), "total coverage 60.0% is below 70.0%")
+	assert.Error(t, c.WithPackageThreshold(50).WithPackage("example.com/b", 20).WithPackage("example.com/a", 90).check(60, packages),
+		"coverage of example.com/a 80.0% is below 90.0%")
+	assert.Error(t, c.WithPackageThreshold(50).check(60, packages),
+		"coverage of example.com/b 25.0% is below 50.0%")
+}
@@ -1,6 +1,171 @@
 package building
 
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Docker returns the docker command line, which cannot run in a container.
 func (b *B) Docker(args ...string) Tool {
-	// $$$$ MAT check what happens with empty instructions
-	return b.MakeTool("docker", "--version", "https://www.docker.com", "")
+	return b.MakeTool("docker", "--version", "https://www.docker.com", "", args...)
+}
+
+// DockerImage wraps building an image of the project.
+type DockerImage struct {
+	dockerfile string
+	context    string
+	tags       []string
+	args       []string
+	target     string
+	labels     []string
+	gitLabels  bool
+}
+
+// DockerImage handles building an image from a dockerfile and a context
+// folder, both relative to the current directory.
+// An empty dockerfile defaults to the Dockerfile of the context.
+func (b *B) DockerImage(dockerfile, context string) DockerImage {
+	if context == "" {
+		context = "."
+	}
+	if dockerfile == "" {
+		dockerfile = filepath.Join(context, "Dockerfile")
+	}
+	return DockerImage{
+		dockerfile: dockerfile,
+		context:    context,
+		gitLabels:  true,
+	}
+}
+
+// WithTag adds tags to the image, defaults to the project name with the
+// latest tag.
+func (i DockerImage) WithTag(tags ...string) DockerImage {
+	i.tags = append(i.tags, tags...)
+	return i
+}
+
+// WithBuildArg sets a build argument.
+func (i DockerImage) WithBuildArg(name, value string) DockerImage {
+	i.args = append(i.args, name+"="+value)
+	return i
+}
+
+// WithTarget builds the stage named target of a multi-stage dockerfile.
+func (i DockerImage) WithTarget(target string) DockerImage {
+	i.target = target
+	return i
+}
+
+// WithLabel adds a label to the image.
+func (i DockerImage) WithLabel(name, value string) DockerImage {
+	i.labels = append(i.labels, name+"="+value)
+	return i
+}
+
+// WithoutGitLabels does not label the image with the revision, version and
+// source of the project.
+func (i DockerImage) WithoutGitLabels() DockerImage {
+	i.gitLabels = false
+	return i
+}
+
+// Build builds the image, the context leaving out the files listed in its
+// .dockerignore.
+func (i DockerImage) Build() {
+	r := containerEngine()
+	c := i.build()
+	b.Println("building image", strings.Join(c.tags, " "))
+	api := r.engine()
+	if api == nil && !r.stdin {
+		b.runEngine(r, r.buildArgs(c), nil)
+		return
+	}
+	buf := &bytes.Buffer{}
+	name, err := buildContext(i.context, i.dockerfile, buf)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	c.context, c.dockerfile = "-", name
+	if api != nil {
+		b.buildEngine(api, c, buf)
+		return
+	}
+	b.runEngine(r, r.buildArgs(c), buf)
+}
+
+// Push pushes the tags of the image to registry, for instance
+// "localhost:5000", or to their own registry if empty.
+func (i DockerImage) Push(registry string) {
+	r := containerEngine()
+	for _, tag := range i.build().tags {
+		if registry != "" {
+			target := pushTarget(registry, tag)
+			b.runEngine(r, r.tagArgs(tag, target), nil)
+			tag = target
+		}
+		b.Println("pushing image", tag)
+		b.runEngine(r, r.pushArgs(tag), nil)
+	}
+}
+
+// build describes building the image from its context folder.
+func (i DockerImage) build() containerBuild {
+	tags := i.tags
+	if len(tags) == 0 {
+		tags = []string{strings.ToLower(path.Base(b.root)) + ":latest"}
+	}
+	labels := i.labels
+	if i.gitLabels {
+		if r := b.gitRepo(); r != nil {
+			labels = append(imageLabels(r, b.GitDirty()), labels...)
+			r.close()
+		}
+	}
+	return containerBuild{
+		tags:       tags,
+		context:    i.context,
+		dockerfile: i.dockerfile,
+		labels:     labels,
+		args:       i.args,
+		target:     i.target,
+		pull:       *pull,
+	}
+}
+
+// imageLabels returns the labels describing the revision, version and source
+// of the project, see https://github.com/opencontainers/image-spec/blob/master/annotations.md
+func imageLabels(r *gitRepo, dirty bool) []string {
+	var labels []string
+	if commit, _, err := r.head(); err == nil {
+		labels = append(labels, "org.opencontainers.image.revision="+commit)
+	}
+	if v, err := version(r); err == nil {
+		v.Dirty = dirty
+		labels = append(labels, "org.opencontainers.image.version="+v.String())
+	}
+	if remote, err := r.remote("origin"); err == nil {
+		// do not leak credentials into the image
+		if u, err := url.Parse(remote); err == nil && u.User != nil {
+			u.User = nil
+			remote = u.String()
+		}
+		labels = append(labels, "org.opencontainers.image.source="+remote)
+	}
+	return labels
+}
+
+// pushTarget replaces the registry of image.
+func pushTarget(registry, image string) string {
+	registry = strings.TrimSuffix(registry, "/")
+	if i := strings.Index(image, "/"); i != -1 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			image = image[i+1:]
+		}
+	}
+	return registry + "/" + image
 }
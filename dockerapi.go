@@ -0,0 +1,463 @@
+package building
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+var (
+	dockerOnce   sync.Once
+	dockerEngine *dockerAPI
+)
+
+// dockerAPI is a client of the Docker Engine API, see
+// https://docs.docker.com/engine/api/
+type dockerAPI struct {
+	client *http.Client
+	url    string
+	osType string
+}
+
+// dockerError is an error returned by the Docker Engine API.
+type dockerError struct {
+	status  int
+	Message string `json:"message"`
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("docker: %s (%d)", e.Message, e.status)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*dockerError)
+	return ok && e.status == http.StatusNotFound
+}
+
+// docker returns a client of the Docker daemon selected by DOCKER_HOST, or
+// nil if it cannot be reached and the command line must be used instead.
+func docker() *dockerAPI {
+	dockerOnce.Do(func() {
+		api, err := newDockerAPI(os.Getenv("DOCKER_HOST"))
+		if err == nil {
+			err = api.ping()
+		}
+		var info dockerInfo
+		if err == nil {
+			info, err = api.info()
+		}
+		if err != nil {
+			b.Debugln("falling back to the docker command line:", err)
+			return
+		}
+		api.osType = info.OSType
+		dockerEngine = api
+	})
+	return dockerEngine
+}
+
+func newDockerAPI(host string) (*dockerAPI, error) {
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		return &dockerAPI{
+			client: &http.Client{Transport: &http.Transport{DialContext: dial}},
+			url:    "http://docker",
+		}, nil
+	case "tcp":
+		if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			return nil, fmt.Errorf("unsupported DOCKER_TLS_VERIFY")
+		}
+		return &dockerAPI{client: &http.Client{}, url: "http://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("unsupported DOCKER_HOST %s", host)
+}
+
+// do sends a request and returns the response if it succeeds, which must
+// then be closed.
+func (a *dockerAPI) do(method, endpoint string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := a.url + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.Debugln(method, endpoint)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer b.Close(resp.Body)
+	e := &dockerError{status: resp.StatusCode}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(content, e) != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(content))
+	}
+	return nil, e
+}
+
+// call sends in as JSON if not nil and decodes the response into out if not
+// nil.
+func (a *dockerAPI) call(method, endpoint string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(content), "application/json"
+	}
+	resp, err := a.do(method, endpoint, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *dockerAPI) ping() error {
+	return a.call("GET", "/_ping", nil, nil, nil)
+}
+
+type dockerInfo struct {
+	OSType string
+}
+
+func (a *dockerAPI) info() (dockerInfo, error) {
+	var info dockerInfo
+	err := a.call("GET", "/info", nil, nil, &info)
+	return info, err
+}
+
+// windows tells whether the daemon runs Windows containers.
+func (a *dockerAPI) windows() bool {
+	return a.osType == "windows"
+}
+
+type dockerImage struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Labels map[string]string
+	}
+}
+
+func (a *dockerAPI) inspect(image string) (dockerImage, error) {
+	var i dockerImage
+	err := a.call("GET", "/images/"+image+"/json", nil, nil, &i)
+	return i, err
+}
+
+// build builds an image from a context tar archive, writing the progress
+// into output.
+func (a *dockerAPI) build(c containerBuild, context io.Reader, output io.Writer) error {
+	query := url.Values{}
+	for _, t := range c.tags {
+		query.Add("t", t)
+	}
+	if c.dockerfile != "" {
+		query.Set("dockerfile", c.dockerfile)
+	}
+	if len(c.labels) > 0 {
+		query.Set("labels", jsonMap(c.labels))
+	}
+	if len(c.args) > 0 {
+		query.Set("buildargs", jsonMap(c.args))
+	}
+	if c.target != "" {
+		query.Set("target", c.target)
+	}
+	if c.pull {
+		query.Set("pull", "1")
+	}
+	query.Set("forcerm", "1")
+	resp, err := a.do("POST", "/build", query, context, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	return progress(resp.Body, output)
+}
+
+// pull pulls an image from its registry, writing the progress into output.
+func (a *dockerAPI) pull(image string, output io.Writer) error {
+	name, tag := image, "latest"
+	if i := strings.Index(image, "@"); i != -1 {
+		name, tag = image[:i], image[i+1:]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+	resp, err := a.do("POST", "/images/create", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	return progress(resp.Body, output)
+}
+
+// progress writes a stream of JSON messages into output, failing on the
+// first error as they come within the stream.
+func progress(r io.Reader, output io.Writer) error {
+	d := json.NewDecoder(r)
+	for {
+		var m struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := d.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if m.Error != "" {
+			return fmt.Errorf("docker: %s", strings.TrimSpace(m.Error))
+		}
+		if m.Status != "" {
+			m.Stream += m.Status + "\n"
+		}
+		if _, err := io.WriteString(output, m.Stream); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonMap encodes "name=value" pairs as a JSON object.
+func jsonMap(pairs []string) string {
+	m := map[string]string{}
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		m[kv[0]] = ""
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		}
+	}
+	content, _ := json.Marshal(m)
+	return string(content)
+}
+
+type containerConfig struct {
+	Image        string
+	Cmd          []string
+	Env          []string
+	WorkingDir   string
+	ExposedPorts map[string]struct{} `json:",omitempty"`
+	HostConfig   hostConfig
+}
+
+type hostConfig struct {
+	Binds        []string
+	PortBindings map[string][]portBinding `json:",omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string
+}
+
+// create creates a container and returns its id, with environ the
+// environment the variables of the container are taken from.
+func (a *dockerAPI) create(c containerRun, environ []string) (string, error) {
+	config := containerConfig{
+		Image:      c.image,
+		Cmd:        c.cmd,
+		WorkingDir: c.dir(),
+		HostConfig: hostConfig{
+			Binds: []string{c.src + ":" + c.dst},
+		},
+	}
+	for _, name := range c.env {
+		if value, ok := lookupEnv(environ, name); ok {
+			config.Env = append(config.Env, name+"="+value)
+		}
+	}
+	for _, p := range c.ports {
+		host, port := "", p
+		if i := strings.LastIndex(p, ":"); i != -1 {
+			host, port = p[:i], p[i+1:]
+		}
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+			config.HostConfig.PortBindings = map[string][]portBinding{}
+		}
+		config.ExposedPorts[port] = struct{}{}
+		binding := portBinding{HostPort: host}
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			binding = portBinding{HostIP: host[:i], HostPort: host[i+1:]}
+		}
+		config.HostConfig.PortBindings[port] = append(config.HostConfig.PortBindings[port], binding)
+	}
+	query := url.Values{}
+	if c.name != "" {
+		query.Set("name", c.name)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := a.call("POST", "/containers/create", query, config, &created)
+	return created.ID, err
+}
+
+func lookupEnv(environ []string, name string) (string, bool) {
+	for i := len(environ) - 1; i >= 0; i-- {
+		if strings.HasPrefix(environ[i], name+"=") {
+			return environ[i][len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+func (a *dockerAPI) start(id string) error {
+	return a.call("POST", "/containers/"+id+"/start", nil, nil, nil)
+}
+
+// logs streams the output of a container until it stops.
+func (a *dockerAPI) logs(id string, stdout, stderr io.Writer) error {
+	query := url.Values{}
+	query.Set("follow", "1")
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	resp, err := a.do("GET", "/containers/"+id+"/logs", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	return demultiplex(resp.Body, stdout, stderr)
+}
+
+// demultiplex splits the output of a container without terminal into its
+// standard and error outputs, each frame starting with an 8 bytes header
+// holding the stream and the size of the payload.
+func demultiplex(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// wait waits for a container to stop and returns its exit code.
+func (a *dockerAPI) wait(id string) (int, error) {
+	var status struct {
+		StatusCode int
+		Error      *struct {
+			Message string
+		}
+	}
+	if err := a.call("POST", "/containers/"+id+"/wait", nil, nil, &status); err != nil {
+		return 0, err
+	}
+	if status.Error != nil && status.Error.Message != "" {
+		return 0, fmt.Errorf("docker: %s", status.Error.Message)
+	}
+	return status.StatusCode, nil
+}
+
+// remove forcibly removes a container along with its anonymous volumes.
+func (a *dockerAPI) remove(id string) error {
+	query := url.Values{}
+	query.Set("force", "1")
+	query.Set("v", "1")
+	return a.call("DELETE", "/containers/"+id, query, nil, nil)
+}
+
+// run runs a container until it stops, then removes it and returns its exit
+// code.
+// An interrupt removes the container right away, as the command line does.
+func (a *dockerAPI) run(c containerRun, environ []string, stdout, stderr io.Writer) (int, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	id, err := a.create(c, environ)
+	if err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	interrupted := make(chan os.Signal, 1)
+	go func() {
+		select {
+		case s := <-signals:
+			interrupted <- s
+			if err := a.remove(id); err != nil {
+				b.Debugln(err)
+			}
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		if err := a.remove(id); err != nil && !isNotFound(err) {
+			b.Check(err)
+		}
+	}()
+	code, err := a.follow(id, stdout, stderr)
+	select {
+	case s := <-interrupted:
+		return 0, fmt.Errorf("interrupted by %s", s)
+	default:
+		return code, err
+	}
+}
+
+// follow starts a container and streams its output until it stops.
+func (a *dockerAPI) follow(id string, stdout, stderr io.Writer) (int, error) {
+	if err := a.start(id); err != nil {
+		return 0, err
+	}
+	if err := a.logs(id, stdout, stderr); err != nil {
+		return 0, err
+	}
+	return a.wait(id)
+}
@@ -0,0 +1,342 @@
+package building
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+// request is a request received by a fake daemon.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+func (r request) String() string {
+	return r.method + " " + r.path
+}
+
+// recorder records the requests received by a fake daemon, so that they get
+// checked on the test goroutine rather than in the handlers.
+type recorder struct {
+	mutex    sync.Mutex
+	requests []request
+}
+
+func (rec *recorder) record(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.mutex.Lock()
+		rec.requests = append(rec.requests, request{r.Method, r.URL.Path, r.URL.Query(), r.Header, body})
+		rec.mutex.Unlock()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// all returns the recorded requests.
+func (rec *recorder) all() []request {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	return append([]request(nil), rec.requests...)
+}
+
+// paths returns the methods and paths of the recorded requests.
+func (rec *recorder) paths() []string {
+	var paths []string
+	for _, r := range rec.all() {
+		paths = append(paths, r.String())
+	}
+	return paths
+}
+
+// fakeDocker serves handler on a unix socket and returns a client of it.
+func fakeDocker(t *testing.T, handler http.Handler) (*dockerAPI, *recorder, func()) {
+	dir := fs.NewDir(t, "docker")
+	l, err := net.Listen("unix", filepath.Join(dir.Path(), "docker.sock"))
+	assert.NilError(t, err)
+	rec := &recorder{}
+	server := httptest.NewUnstartedServer(rec.record(handler))
+	server.Listener = l
+	server.Start()
+	api, err := newDockerAPI("unix://" + filepath.Join(dir.Path(), "docker.sock"))
+	assert.NilError(t, err)
+	return api, rec, func() {
+		server.Close()
+		dir.Remove()
+	}
+}
+
+func TestNewDockerAPI(t *testing.T) {
+	api, err := newDockerAPI("tcp://127.0.0.1:2375")
+	assert.NilError(t, err)
+	assert.Equal(t, api.url, "http://127.0.0.1:2375")
+	api, err = newDockerAPI("")
+	assert.NilError(t, err)
+	assert.Equal(t, api.url, "http://docker")
+	_, err = newDockerAPI("ssh://me@host")
+	assert.Error(t, err, "unsupported DOCKER_HOST ssh://me@host")
+}
+
+func TestDockerAPIInspect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"OSType":"windows"}`))
+	})
+	mux.HandleFunc("/images/alpine:3.8/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"sha256:1234","Config":{"Labels":{"brique.hash":"5678"}}}`))
+	})
+	mux.HandleFunc("/images/missing/json", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such image: missing"}`))
+	})
+	api, _, stop := fakeDocker(t, mux)
+	defer stop()
+
+	info, err := api.info()
+	assert.NilError(t, err)
+	assert.Equal(t, info.OSType, "windows")
+	i, err := api.inspect("alpine:3.8")
+	assert.NilError(t, err)
+	assert.Equal(t, i.ID, "sha256:1234")
+	assert.Equal(t, i.Config.Labels[hashLabel], "5678")
+	_, err = api.inspect("missing")
+	assert.Error(t, err, "docker: No such image: missing (404)")
+	assert.Assert(t, isNotFound(err))
+}
+
+func TestDockerAPIBuild(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"stream":"Step 1/2 : FROM scratch\n"}` + "\n"))
+		w.Write([]byte(`{"stream":"Successfully built 1234\n"}`))
+	})
+	api, rec, stop := fakeDocker(t, mux)
+	defer stop()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	assert.NilError(t, addContextFile(tw, "build/Dockerfile", []byte("FROM scratch")))
+	assert.NilError(t, tw.Close())
+	output := &bytes.Buffer{}
+	err := api.build(containerBuild{
+		tags:       []string{"app:1.0.0", "app:latest"},
+		dockerfile: "build/Dockerfile",
+		labels:     []string{"brique.hash=1234"},
+		args:       []string{"GO_VERSION=1.11"},
+		target:     "release",
+	}, buf, output)
+	assert.NilError(t, err)
+	assert.Equal(t, output.String(), "Step 1/2 : FROM scratch\nSuccessfully built 1234\n")
+	requests := rec.all()
+	assert.Equal(t, len(requests), 1)
+	r := requests[0]
+	assert.Equal(t, r.String(), "POST /build")
+	assert.Equal(t, r.header.Get("Content-Type"), "application/x-tar")
+	assert.DeepEqual(t, r.query["t"], []string{"app:1.0.0", "app:latest"})
+	assert.Equal(t, r.query.Get("dockerfile"), "build/Dockerfile")
+	assert.Equal(t, r.query.Get("labels"), `{"brique.hash":"1234"}`)
+	assert.Equal(t, r.query.Get("buildargs"), `{"GO_VERSION":"1.11"}`)
+	assert.Equal(t, r.query.Get("target"), "release")
+	hdr, err := tar.NewReader(bytes.NewReader(r.body)).Next()
+	assert.NilError(t, err)
+	assert.Equal(t, hdr.Name, "build/Dockerfile")
+}
+
+func TestDockerAPIBuildError(t *testing.T) {
+	api, _, stop := fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"stream":"Step 1/2 : RUN false\n"}` + "\n"))
+		w.Write([]byte(`{"errorDetail":{"code":1,"message":"returned a non-zero code: 1"},"error":"returned a non-zero code: 1"}`))
+	}))
+	defer stop()
+
+	output := &bytes.Buffer{}
+	err := api.build(containerBuild{tags: []string{"image"}}, strings.NewReader(""), output)
+	assert.Error(t, err, "docker: returned a non-zero code: 1")
+	assert.Equal(t, output.String(), "Step 1/2 : RUN false\n")
+}
+
+func TestDockerAPIPull(t *testing.T) {
+	api, rec, stop := fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"Pulling from library/alpine"}` + "\n"))
+		if r.URL.Query().Get("fromImage") == "missing" {
+			w.Write([]byte(`{"error":"manifest unknown"}`))
+		}
+	}))
+	defer stop()
+
+	output := &bytes.Buffer{}
+	assert.NilError(t, api.pull("alpine:3.8", output))
+	assert.Equal(t, output.String(), "Pulling from library/alpine\n")
+	assert.NilError(t, api.pull("localhost:5000/golang", ioutil.Discard))
+	assert.NilError(t, api.pull("alpine@sha256:1234", ioutil.Discard))
+	assert.Error(t, api.pull("missing", ioutil.Discard), "docker: manifest unknown")
+	var pulled []string
+	for _, r := range rec.all() {
+		pulled = append(pulled, r.String()+" "+r.query.Get("fromImage")+" "+r.query.Get("tag"))
+	}
+	assert.DeepEqual(t, pulled, []string{
+		"POST /images/create alpine 3.8",
+		"POST /images/create localhost:5000/golang latest",
+		"POST /images/create alpine sha256:1234",
+		"POST /images/create missing latest",
+	})
+}
+
+func TestDockerAPIRun(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"abcd"}`))
+	})
+	mux.HandleFunc("/containers/abcd/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/abcd/start":
+			w.WriteHeader(http.StatusNoContent)
+		case "/containers/abcd/logs":
+			w.Write(frame(1, "go version go1.11\n"))
+			w.Write(frame(2, "warning\n"))
+			w.Write(frame(1, "done\n"))
+		case "/containers/abcd/wait":
+			w.Write([]byte(`{"StatusCode":3}`))
+		}
+	})
+	mux.HandleFunc("/containers/abcd", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api, rec, stop := fakeDocker(t, mux)
+	defer stop()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code, err := api.run(containerRun{
+		image: "image",
+		name:  "brique-1234",
+		src:   "/src",
+		dst:   "/go/src/pkg",
+		env:   []string{"A", "B"},
+		ports: []string{"127.0.0.1:15432:5432"},
+		cmd:   []string{"go", "version"},
+	}, []string{"A=1", "C=3", "A=2"}, stdout, stderr)
+	assert.NilError(t, err)
+	assert.Equal(t, code, 3)
+	assert.Equal(t, stdout.String(), "go version go1.11\ndone\n")
+	assert.Equal(t, stderr.String(), "warning\n")
+	assert.DeepEqual(t, rec.paths(), []string{
+		"POST /containers/create",
+		"POST /containers/abcd/start",
+		"GET /containers/abcd/logs",
+		"POST /containers/abcd/wait",
+		"DELETE /containers/abcd",
+	})
+	requests := rec.all()
+	assert.Equal(t, requests[0].query.Get("name"), "brique-1234")
+	var config containerConfig
+	assert.NilError(t, json.Unmarshal(requests[0].body, &config))
+	assert.DeepEqual(t, config, containerConfig{
+		Image:        "image",
+		Cmd:          []string{"go", "version"},
+		Env:          []string{"A=2"},
+		WorkingDir:   "/go/src/pkg",
+		ExposedPorts: map[string]struct{}{"5432/tcp": {}},
+		HostConfig: hostConfig{
+			Binds:        []string{"/src:/go/src/pkg"},
+			PortBindings: map[string][]portBinding{"5432/tcp": {{HostIP: "127.0.0.1", HostPort: "15432"}}},
+		},
+	})
+	assert.Equal(t, requests[2].query.Get("follow"), "1")
+	assert.Equal(t, requests[4].query.Get("force"), "1")
+}
+
+func TestDockerAPIRunRemovesOnFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"abcd"}`))
+	})
+	mux.HandleFunc("/containers/abcd/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message":"port is already allocated"}`))
+	})
+	mux.HandleFunc("/containers/abcd", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api, rec, stop := fakeDocker(t, mux)
+	defer stop()
+
+	_, err := api.run(containerRun{image: "image"}, nil, ioutil.Discard, ioutil.Discard)
+	assert.Error(t, err, "docker: port is already allocated (500)")
+	assert.DeepEqual(t, rec.paths(), []string{
+		"POST /containers/create",
+		"POST /containers/abcd/start",
+		"DELETE /containers/abcd",
+	})
+}
+
+func TestDockerAPIRunRemovesOnInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts cannot be sent on windows")
+	}
+	removed := make(chan struct{})
+	var once sync.Once
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"abcd"}`))
+	})
+	mux.HandleFunc("/containers/abcd/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/abcd/start":
+			p, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				p.Signal(os.Interrupt)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "/containers/abcd/logs":
+			<-removed
+		case "/containers/abcd/wait":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container: abcd"}`))
+		}
+	})
+	mux.HandleFunc("/containers/abcd", func(w http.ResponseWriter, r *http.Request) {
+		first := false
+		once.Do(func() {
+			close(removed)
+			first = true
+		})
+		if first {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container: abcd"}`))
+	})
+	api, _, stop := fakeDocker(t, mux)
+	defer stop()
+
+	_, err := api.run(containerRun{image: "image"}, nil, ioutil.Discard, ioutil.Discard)
+	assert.Error(t, err, "interrupted by interrupt")
+}
+
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
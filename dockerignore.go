@@ -0,0 +1,294 @@
+package building
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignorePattern is a line of a .dockerignore file, see
+// https://docs.docker.com/engine/reference/builder/#dockerignore-file
+type ignorePattern struct {
+	re        *regexp.Regexp
+	exclusion bool
+}
+
+// readDockerignore reads the .dockerignore file of a build context, if any.
+func readDockerignore(dir string) ([]ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close(f)
+	return parseDockerignore(f)
+}
+
+func parseDockerignore(r io.Reader) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.exclusion = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		if line == "." || line == "" {
+			continue
+		}
+		re, err := compileIgnore(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %q: %s", line, err)
+		}
+		p.re = re
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// compileIgnore translates a pattern into a regular expression, with **
+// matching any number of folders.
+func compileIgnore(pattern string) (*regexp.Regexp, error) {
+	re := "^"
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re += "(.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re += ".*"
+			i++
+		case c == '*':
+			re += "[^/]*"
+		case c == '?':
+			re += "[^/]"
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j == -1 {
+				return nil, fmt.Errorf("missing ]")
+			}
+			class := pattern[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re += "[" + class + "]"
+			i += j
+		case c == '\\' && i+1 < len(pattern):
+			re += regexp.QuoteMeta(pattern[i+1 : i+2])
+			i++
+		default:
+			re += regexp.QuoteMeta(pattern[i : i+1])
+		}
+	}
+	return regexp.Compile(re + "$")
+}
+
+// match tells whether the pattern matches rel or one of its parent folders.
+func (p ignorePattern) match(rel string) bool {
+	for {
+		if p.re.MatchString(rel) {
+			return true
+		}
+		i := strings.LastIndex(rel, "/")
+		if i == -1 {
+			return false
+		}
+		rel = rel[:i]
+	}
+}
+
+// ignored tells whether rel is excluded from the build context, the last
+// matching pattern winning.
+func ignored(patterns []ignorePattern, rel string) bool {
+	ignore := false
+	for _, p := range patterns {
+		if p.match(rel) {
+			ignore = !p.exclusion
+		}
+	}
+	return ignore
+}
+
+func hasExclusions(patterns []ignorePattern) bool {
+	for _, p := range patterns {
+		if p.exclusion {
+			return true
+		}
+	}
+	return false
+}
+
+// buildContext writes the folder dir as a tar archive suitable for building
+// an image, leaving out the files listed in its .dockerignore.
+// It returns the name of the dockerfile in the archive, added as .dockerfile
+// if located outside of dir.
+func buildContext(dir, dockerfile string, w io.Writer) (string, error) {
+	name := ""
+	if rel, err := filepath.Rel(dir, dockerfile); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		name = filepath.ToSlash(rel)
+	}
+	tw := tar.NewWriter(w)
+	if err := addContext(tw, dir, name, ".dockerignore"); err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = ".dockerfile"
+		content, err := ioutil.ReadFile(dockerfile)
+		if err != nil {
+			return "", err
+		}
+		if err := addContextFile(tw, name, content); err != nil {
+			return "", err
+		}
+	}
+	return name, tw.Close()
+}
+
+// toolContext writes the folders dirs, leaving out the files listed in their
+// .dockerignore, and the instructions as .dockerfile into a tar archive.
+// The folders get merged without their .dockerignore which would otherwise
+// apply to all of them, and must not have files in common.
+func toolContext(dirs []string, instructions string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	seen := map[string]bool{}
+	files := map[string]string{}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		err = walkContext(dir, nil, func(path, rel string, info os.FileInfo) error {
+			if !info.IsDir() {
+				if other, ok := files[rel]; ok {
+					return fmt.Errorf("%s is in both build contexts %s and %s", rel, other, dir)
+				}
+				files[rel] = dir
+			}
+			return writeContextFile(tw, path, rel, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := addContextFile(tw, ".dockerfile", []byte(instructions)); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addContext adds the content of dir not ignored by its .dockerignore, apart
+// from the files to keep which are always added.
+func addContext(tw *tar.Writer, dir string, keep ...string) error {
+	return walkContext(dir, keep, func(path, rel string, info os.FileInfo) error {
+		return writeContextFile(tw, path, rel, info)
+	})
+}
+
+// walkContext calls add for the content of dir not ignored by its
+// .dockerignore, apart from the files to keep.
+func walkContext(dir string, keep []string, add func(path, rel string, info os.FileInfo) error) error {
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if !contains(keep, rel) && (rel == ".dockerignore" || ignored(patterns, rel)) {
+			if info.IsDir() && !hasExclusions(patterns) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		b.Debugln("adding", rel, "to build context")
+		return add(path, rel, info)
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func addContextFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+// contextDigest identifies the content of a build context tar archive,
+// ignoring the modification times.
+func contextDigest(context []byte) (string, error) {
+	h := sha256.New()
+	tr := tar.NewReader(bytes.NewReader(context))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %o %s %d\n", hdr.Name, hdr.Mode, hdr.Linkname, hdr.Size)
+		if _, err := io.Copy(h, tr); err != nil {
+			return "", err
+		}
+	}
+}
+
+func writeContextFile(tw *tar.Writer, path, rel string, info os.FileInfo) error {
+	mode := info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		return tw.WriteHeader(hdr)
+	}
+	if !mode.IsRegular() && !mode.IsDir() {
+		b.Debugln("skipping special file", rel)
+		return nil
+	}
+	return writeTar(tw, path, rel, info)
+}
@@ -0,0 +1,165 @@
+package building
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestDockerignore(t *testing.T) {
+	patterns, err := parseDockerignore(strings.NewReader(`
+# comment
+/bin
+*.log
+**/*.tmp
+docs/**
+!docs/README.md
+vendor/*/testdata
+f?o[0-9]
+`))
+	assert.NilError(t, err)
+	for rel, expected := range map[string]bool{
+		"bin":                        true,
+		"bin/app":                    true,
+		"cmd/bin":                    false,
+		"error.log":                  true,
+		"logs/error.log":             false,
+		"a.tmp":                      true,
+		"a/b/c.tmp":                  true,
+		"docs":                       false,
+		"docs/guide.md":              true,
+		"docs/README.md":             false,
+		"vendor/pkg/testdata/in.txt": true,
+		"vendor/pkg/a.go":            false,
+		"fxo1":                       true,
+		"fxo":                        false,
+		"main.go":                    false,
+	} {
+		assert.Equal(t, ignored(patterns, rel), expected, rel)
+	}
+	_, err = parseDockerignore(strings.NewReader("[a"))
+	assert.Error(t, err, `invalid .dockerignore pattern "[a": missing ]`)
+}
+
+func TestBuildContext(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithDir("context",
+			fs.WithFile(".dockerignore", "*.log\nbuild\n!build/keep\n"),
+			fs.WithFile("Dockerfile", "FROM scratch"),
+			fs.WithFile("main.go", "package main"),
+			fs.WithFile("debug.log", "debug"),
+			fs.WithDir("build",
+				fs.WithFile("keep", "keep"),
+				fs.WithFile("app", "app")),
+			fs.WithSymlink("link", "main.go")),
+		fs.WithFile("Dockerfile.release", "FROM alpine"))
+	defer rootDirectory.Remove()
+	context := filepath.Join(rootDirectory.Path(), "context")
+
+	buf := &bytes.Buffer{}
+	name, err := buildContext(context, filepath.Join(context, "Dockerfile"), buf)
+	assert.NilError(t, err)
+	assert.Equal(t, name, "Dockerfile")
+	files := contextFiles(t, buf)
+	assert.DeepEqual(t, files, map[string]string{
+		".dockerignore": "*.log\nbuild\n!build/keep\n",
+		"Dockerfile":    "FROM scratch",
+		"build/keep":    "keep",
+		"link":          "-> " + filepath.Join(context, "main.go"),
+		"main.go":       "package main",
+	})
+
+	buf.Reset()
+	name, err = buildContext(context, filepath.Join(rootDirectory.Path(), "Dockerfile.release"), buf)
+	assert.NilError(t, err)
+	assert.Equal(t, name, ".dockerfile")
+	files = contextFiles(t, buf)
+	assert.Equal(t, files[".dockerfile"], "FROM alpine")
+}
+
+func contextFiles(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			files[hdr.Name] = "dir"
+		case tar.TypeSymlink:
+			files[hdr.Name] = "-> " + hdr.Linkname
+		default:
+			content, err := ioutil.ReadAll(tr)
+			assert.NilError(t, err)
+			files[hdr.Name] = string(content)
+		}
+	}
+	return files
+}
+
+func TestToolContext(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithDir("a",
+			fs.WithFile(".dockerignore", "*.log"),
+			fs.WithFile("entrypoint.sh", "a"),
+			fs.WithFile("debug.log", "debug")),
+		fs.WithDir("b",
+			fs.WithFile("config.yml", "b")))
+	defer rootDirectory.Remove()
+
+	buf := &bytes.Buffer{}
+	err := toolContext([]string{
+		filepath.Join(rootDirectory.Path(), "a"),
+		filepath.Join(rootDirectory.Path(), "b"),
+		filepath.Join(rootDirectory.Path(), "b"),
+	}, "FROM alpine:3.8\nCOPY entrypoint.sh /", buf)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, contextFiles(t, buf), map[string]string{
+		"entrypoint.sh": "a",
+		"config.yml":    "b",
+		".dockerfile":   "FROM alpine:3.8\nCOPY entrypoint.sh /",
+	})
+}
+
+func TestToolContextConflict(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithDir("a", fs.WithFile("entrypoint.sh", "a")),
+		fs.WithDir("b", fs.WithFile("entrypoint.sh", "b")))
+	defer rootDirectory.Remove()
+
+	first := filepath.Join(rootDirectory.Path(), "a")
+	second := filepath.Join(rootDirectory.Path(), "b")
+	err := toolContext([]string{first, second}, "FROM alpine:3.8", ioutil.Discard)
+	assert.Error(t, err, "entrypoint.sh is in both build contexts "+first+" and "+second)
+}
+
+func TestContextDigest(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root", fs.WithFile("entrypoint.sh", "#!/bin/sh"))
+	defer rootDirectory.Remove()
+	digest := func() string {
+		buf := &bytes.Buffer{}
+		assert.NilError(t, toolContext([]string{rootDirectory.Path()}, "FROM alpine:3.8", buf))
+		d, err := contextDigest(buf.Bytes())
+		assert.NilError(t, err)
+		return d
+	}
+	before := digest()
+	file := filepath.Join(rootDirectory.Path(), "entrypoint.sh")
+	later := time.Now().Add(time.Hour)
+	assert.NilError(t, os.Chtimes(file, later, later))
+	assert.Equal(t, digest(), before)
+	assert.NilError(t, ioutil.WriteFile(file, []byte("#!/bin/bash"), 0644))
+	assert.Assert(t, digest() != before)
+}
@@ -0,0 +1,169 @@
+package building
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type download struct {
+	platform string
+	url      string
+	sum      string
+}
+
+// download fetches the tool for the current platform into the cache and
+// returns the path of its binary, or an empty string if not available.
+func (t Tool) download() string {
+	if *offline {
+		return ""
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	for _, d := range t.downloads {
+		if d.platform != platform {
+			continue
+		}
+		dir := filepath.Join(cacheDir(), "tools", d.sum)
+		name := t.name + b.Exe(runtime.GOOS)
+		if p, err := findFile(dir, name); err == nil {
+			return p
+		}
+		b.Println("downloading", t.name, "from", d.url)
+		if err := fetch(d.url, d.sum, name, dir); err != nil {
+			b.Fatalln(err)
+		}
+		p, err := findFile(dir, name)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		return p
+	}
+	return ""
+}
+
+// fetch downloads url into dir after checking its sha256 sum, extracting it
+// if it's an archive or naming it name otherwise.
+func fetch(url, sum, name, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	// tools sharing a download may fetch it at the same time
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), filepath.Base(dir)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		b.Check(os.RemoveAll(tmp))
+	}()
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	base := path.Base(strings.SplitN(url, "?", 2)[0])
+	archive := isArchive(base)
+	file := filepath.Join(tmp, name)
+	if archive {
+		file = filepath.Join(tmp, base)
+	}
+	if err := save(resp.Body, file, sum); err != nil {
+		return fmt.Errorf("failed to download %s: %s", url, err)
+	}
+	if archive {
+		if strings.HasSuffix(base, ".zip") {
+			err = unzipFiles(file, tmp)
+		} else {
+			err = untarFiles(file, tmp)
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+func save(r io.Reader, file, sum string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer b.Close(f)
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("checksum mismatch, expected %s got %s", sum, actual)
+	}
+	return nil
+}
+
+func isArchive(name string) bool {
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func findFile(dir, name string) (string, error) {
+	found := ""
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if found == "" && !info.IsDir() && info.Name() == name {
+			found = p
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s not found in %s", name, dir)
+	}
+	return found, nil
+}
+
+// cacheDir returns the folder where brique keeps downloaded files, which can
+// be set with $BRIQUE_CACHE.
+func cacheDir() string {
+	if dir := os.Getenv("BRIQUE_CACHE"); dir != "" {
+		return dir
+	}
+	dir := os.Getenv("XDG_CACHE_HOME")
+	switch {
+	case runtime.GOOS == "windows":
+		dir = os.Getenv("LocalAppData")
+	case runtime.GOOS == "darwin":
+		dir = filepath.Join(os.Getenv("HOME"), "Library", "Caches")
+	case dir == "":
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "brique")
+}
@@ -0,0 +1,204 @@
+package building
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+const script = "#!/bin/sh\necho ok\n"
+
+func makeRelease(t *testing.T) (*httptest.Server, *int32) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "tool-1.0/", Mode: 0755, Typeflag: tar.TypeDir}))
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "tool-1.0/tool", Mode: 0755, Size: int64(len(script))}))
+	_, err := tw.Write([]byte(script))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, gz.Close())
+	archive := buf.Bytes()
+	var hits int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/tool-linux-amd64":
+			w.Write([]byte(script))
+		case "/tool-1.0.tar.gz":
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s, &hits
+}
+
+func sum(t *testing.T, s *httptest.Server, path string) string {
+	resp, err := http.Get(s.URL + path)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+func TestFetchBinary(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	dir := filepath.Join(rootDirectory.Path(), "cache")
+	err := fetch(s.URL+"/tool-linux-amd64", sum(t, s, "/tool-linux-amd64"), "tool", dir)
+	assert.NilError(t, err)
+
+	expected := fs.Expected(t,
+		fs.WithDir("cache",
+			fs.WithFile("tool", script, fs.WithMode(0755))))
+	assert.Assert(t, fs.Equal(rootDirectory.Path(), expected))
+}
+
+func TestFetchArchive(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	dir := filepath.Join(rootDirectory.Path(), "cache")
+	err := fetch(s.URL+"/tool-1.0.tar.gz", sum(t, s, "/tool-1.0.tar.gz"), "tool", dir)
+	assert.NilError(t, err)
+	path, err := findFile(dir, "tool")
+	assert.NilError(t, err)
+	assert.Equal(t, path, filepath.Join(dir, "tool-1.0", "tool"))
+}
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	dir := filepath.Join(rootDirectory.Path(), "cache")
+	err := fetch(s.URL+"/tool-linux-amd64", "1234", "tool", dir)
+	assert.ErrorContains(t, err, "checksum mismatch, expected 1234 got ")
+	_, err = os.Stat(dir)
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestFetchNotFound(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	err := fetch(s.URL+"/missing", "1234", "tool", filepath.Join(rootDirectory.Path(), "cache"))
+	assert.Error(t, err, "failed to download "+s.URL+"/missing: 404 Not Found")
+}
+
+func TestToolDownload(t *testing.T) {
+	s, hits := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+	defer os.Setenv("BRIQUE_CACHE", os.Getenv("BRIQUE_CACHE"))
+	os.Setenv("BRIQUE_CACHE", rootDirectory.Path())
+
+	checksum := sum(t, s, "/tool-1.0.tar.gz")
+	tool := Tool{name: "tool"}.
+		WithDownload("plan9/arm", s.URL+"/missing", "1234").
+		WithDownload(runtime.GOOS+"/"+runtime.GOARCH, s.URL+"/tool-1.0.tar.gz", checksum)
+	expected := filepath.Join(rootDirectory.Path(), "tools", checksum, "tool-1.0", "tool")
+	atomic.StoreInt32(hits, 0)
+	assert.Equal(t, tool.download(), expected)
+	assert.Equal(t, tool.download(), expected)
+	assert.Equal(t, atomic.LoadInt32(hits), int32(1))
+	assert.Equal(t, Tool{name: "tool"}.download(), "")
+}
+
+func TestToolDownloadVersion(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+	defer os.Setenv("BRIQUE_CACHE", os.Getenv("BRIQUE_CACHE"))
+	os.Setenv("BRIQUE_CACHE", rootDirectory.Path())
+	defer useBuilder()()
+
+	tool := Tool{name: "brique-missing-tool", instructions: "FROM scratch"}.
+		WithDownload(runtime.GOOS+"/"+runtime.GOARCH, s.URL+"/tool-linux-amd64", sum(t, s, "/tool-linux-amd64"))
+	version := func(v string) func(string) string {
+		return func(output string) string {
+			assert.Equal(t, output, "ok\n")
+			return v
+		}
+	}
+	d := tool.WithVersion(">=1.0", version("1.0")).locate()
+	assert.Assert(t, !d.container)
+	assert.Equal(t, filepath.Base(d.path), "brique-missing-tool")
+	d = tool.WithVersion(">=1.0", version("0.9")).locate()
+	assert.Assert(t, d.container)
+}
+
+func TestToolDetectFailure(t *testing.T) {
+	s, _ := makeRelease(t)
+	defer s.Close()
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+	defer os.Setenv("BRIQUE_CACHE", os.Getenv("BRIQUE_CACHE"))
+	os.Setenv("BRIQUE_CACHE", rootDirectory.Path())
+	defer useBuilder()()
+
+	tool := Tool{name: "brique-missing-tool", url: "https://example.com"}.
+		WithDownload(runtime.GOOS+"/"+runtime.GOARCH, s.URL+"/tool-linux-amd64", sum(t, s, "/tool-linux-amd64")).
+		WithVersion(">=1.0", func(string) string { return "0.9" })
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "missing brique-missing-tool >=1.0 (downloaded 0.9), see https://example.com\n"), buf.String())
+	}()
+	tool.detect()
+}
+
+func TestToolDownloadChecksumMismatch(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+	defer os.Setenv("BRIQUE_CACHE", os.Getenv("BRIQUE_CACHE"))
+	os.Setenv("BRIQUE_CACHE", rootDirectory.Path())
+	defer useBuilder()()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the download must not hold the builder lock
+		b.mutex.Lock()
+		b.mutex.Unlock()
+		w.Write([]byte(script))
+	}))
+	defer s.Close()
+
+	tool := Tool{name: "brique-missing-tool"}.
+		WithDownload(runtime.GOOS+"/"+runtime.GOARCH, s.URL+"/tool-linux-amd64", "1234")
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "checksum mismatch, expected 1234 got "), buf.String())
+	}()
+	tool.detect()
+}
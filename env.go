@@ -0,0 +1,168 @@
+package building
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minSecretLength is the length under which secrets do not get masked, as
+// they would mangle the logs without hiding much.
+const minSecretLength = 4
+
+var (
+	secrets      []string
+	secretsMutex sync.Mutex
+)
+
+// LoadEnv reads variables from dotenv files and passes them to all tools and
+// commands. Variables already set in the environment take precedence.
+func (b *B) LoadEnv(files ...string) {
+	for _, file := range files {
+		env, err := readEnv(file)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		b.mutex.Lock()
+		for _, e := range env {
+			if _, ok := os.LookupEnv(envName(e)); !ok {
+				b.env = append(b.env, e)
+			}
+		}
+		b.mutex.Unlock()
+	}
+}
+
+// Secret returns the value of a variable from the environment or the loaded
+// env files, and masks it in all the build logs unless shorter than
+// minSecretLength.
+func (b *B) Secret(name string) string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		for _, e := range b.loadedEnv() {
+			if envName(e) == name {
+				value, ok = e[len(name)+1:], true
+			}
+		}
+	}
+	if !ok || value == "" {
+		b.Fatalln("missing secret", name)
+	}
+	if len(value) < minSecretLength {
+		b.Println("secret", name, "too short to be masked")
+		return value
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	secrets = append(secrets, value)
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return value
+}
+
+func (b *B) loadedEnv() []string {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.env...)
+}
+
+// environ returns the environment of the build with the loaded env files and
+// env added.
+func (b *B) environ(env []string) []string {
+	return append(append(os.Environ(), b.loadedEnv()...), env...)
+}
+
+func envName(e string) string {
+	return strings.SplitN(e, "=", 2)[0]
+}
+
+func envNames(env []string) []string {
+	var names []string
+	for _, e := range env {
+		names = append(names, envName(e))
+	}
+	return names
+}
+
+func readEnv(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close(f)
+	env, err := parseEnv(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return env, nil
+}
+
+// parseEnv reads KEY=VALUE lines, ignoring empty lines, comments and export
+// prefixes, with values optionally quoted.
+func parseEnv(r io.Reader) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable %q", n, line)
+		}
+		value := strings.TrimSpace(kv[1])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid value %s", n, value)
+			}
+			value = v
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: invalid value %s", n, value)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if i := strings.Index(value, " #"); i != -1 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, scanner.Err()
+}
+
+// mask replaces the secrets in s with ***.
+func mask(s string) string {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	return s
+}
+
+// masker writes to w with the secrets masked.
+type masker struct {
+	w io.Writer
+}
+
+func (m masker) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(m.w, mask(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
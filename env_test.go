@@ -0,0 +1,74 @@
+package building
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestParseEnv(t *testing.T) {
+	env, err := parseEnv(strings.NewReader(`
+# comment
+A=1
+export B = 2
+C="quoted \"value\"\n"
+D='single # quoted'
+E=value # comment
+F=
+`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, env, []string{
+		"A=1",
+		"B=2",
+		"C=quoted \"value\"\n",
+		"D=single # quoted",
+		"E=value",
+		"F=",
+	})
+}
+
+func TestParseInvalidEnv(t *testing.T) {
+	_, err := parseEnv(strings.NewReader("A=1\nB"))
+	assert.Error(t, err, `line 2: invalid variable "B"`)
+	_, err = parseEnv(strings.NewReader(`A="unterminated`))
+	assert.Error(t, err, `line 1: invalid value "unterminated`)
+}
+
+func TestLoadEnv(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile(".env", "BRIQUE_TEST_A=1\nBRIQUE_TEST_B=2\nBRIQUE_TEST_SECRET=s3cr3t\n"),
+		fs.WithFile(".env.local", "BRIQUE_TEST_B=3\n"))
+	defer rootDirectory.Remove()
+	defer os.Unsetenv("BRIQUE_TEST_A")
+	os.Setenv("BRIQUE_TEST_A", "0")
+	defer func(s []string) { secrets = s }(secrets)
+
+	b := &B{}
+	b.LoadEnv(filepath.Join(rootDirectory.Path(), ".env"), filepath.Join(rootDirectory.Path(), ".env.local"))
+	assert.DeepEqual(t, b.loadedEnv(), []string{"BRIQUE_TEST_B=2", "BRIQUE_TEST_SECRET=s3cr3t", "BRIQUE_TEST_B=3"})
+	assert.Equal(t, b.Secret("BRIQUE_TEST_B"), "3")
+	assert.Equal(t, b.Secret("BRIQUE_TEST_A"), "0")
+	assert.Equal(t, b.Secret("BRIQUE_TEST_SECRET"), "s3cr3t")
+	assert.Equal(t, mask("token s3cr3t"), "token ***")
+	assert.Equal(t, mask("B=3 A=0"), "B=3 A=0")
+
+	env := b.environ([]string{"C=4"})
+	assert.DeepEqual(t, env[len(env)-4:], []string{"BRIQUE_TEST_B=2", "BRIQUE_TEST_SECRET=s3cr3t", "BRIQUE_TEST_B=3", "C=4"})
+}
+
+func TestMask(t *testing.T) {
+	defer func(s []string) { secrets = s }(secrets)
+	secrets = []string{"s3cr3t-longer", "s3cr3t"}
+	assert.Equal(t, mask("token s3cr3t and s3cr3t-longer"), "token *** and ***")
+
+	buf := &bytes.Buffer{}
+	n, err := masker{w: buf}.Write([]byte("token=s3cr3t\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 13)
+	assert.Equal(t, buf.String(), "token=***\n")
+}
@@ -1,46 +1,142 @@
 package building
 
 import (
-	"bytes"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 func (b *B) Git(args ...string) Tool {
-	return b.MakeTool(
+	t := b.MakeTool(
 		"git",
 		"--version",
 		"https://git-scm.com",
 		`
 FROM alpine:`+AlpineVersion+`
-RUN apk add --no-cache git`,
-		args...)
+RUN apk add --no-cache git`).
+		WithPackages("git")
+	if len(args) > 0 {
+		t.Run(args...)
+	}
+	return t
+}
+
+// gitRepo opens the repository of the project, or returns nil if there is
+// none.
+func (b *B) gitRepo() *gitRepo {
+	r, err := openGit(".")
+	if err != nil {
+		b.Debugln("git:", err)
+		return nil
+	}
+	return r
 }
 
 func (b *B) GitShortCommit() string {
-	buf := &bytes.Buffer{}
-	b.Git().WithOutput(buf).WithSuccess().Run("rev-parse", "--short", "HEAD")
-	return strings.TrimSpace(buf.String())
+	commit := b.GitCommit()
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
 }
 
 func (b *B) GitCommit() string {
-	buf := &bytes.Buffer{}
-	b.Git().WithOutput(buf).WithSuccess().Run("rev-parse", "HEAD")
-	return strings.TrimSpace(buf.String())
+	r := b.gitRepo()
+	if r == nil {
+		return ""
+	}
+	commit, _, err := r.head()
+	if err != nil {
+		b.Debugln("git:", err)
+	}
+	return commit
+}
+
+// GitBranch returns the branch checked out, or an empty string if HEAD is
+// detached.
+func (b *B) GitBranch() string {
+	r := b.gitRepo()
+	if r == nil {
+		return ""
+	}
+	_, branch, err := r.head()
+	if err != nil {
+		b.Debugln("git:", err)
+	}
+	return branch
 }
 
+// GitTag describes HEAD like git describe --always --dirty.
 func (b *B) GitTag() string {
-	buf := &bytes.Buffer{}
-	b.Git().WithOutput(buf).WithSuccess().Run("describe", "--always", "--dirty")
-	return strings.TrimSpace(buf.String())
+	tag := b.gitDescribe("", false)
+	if tag != "" && b.GitDirty() {
+		tag += "-dirty"
+	}
+	return tag
+}
+
+func (b *B) gitDescribe(pattern string, all bool) string {
+	r := b.gitRepo()
+	if r == nil {
+		return ""
+	}
+	defer r.close()
+	commit, _, err := r.head()
+	if err != nil {
+		b.Debugln("git:", err)
+		return ""
+	}
+	tag, distance, err := r.describe(commit, pattern, all)
+	if err != nil {
+		b.Debugln("git:", err)
+	}
+	return formatDescribe(tag, distance, commit)
+}
+
+func formatDescribe(tag string, distance int, commit string) string {
+	if tag == "" {
+		if len(commit) > 7 {
+			return commit[:7]
+		}
+		return commit
+	}
+	if distance == 0 {
+		return tag
+	}
+	return tag + "-" + strconv.Itoa(distance) + "-g" + commit[:7]
 }
 
+// GitDirty returns true if tracked files have been modified and false if
+// the working tree is clean, using the git tool as the index cannot be
+// checked otherwise.
 func (b *B) GitDirty() bool {
 	b.Git("update-index", "-q", "--refresh")
-	return b.Git().WithSuccess().Run("diff-index", "--quiet", "HEAD", "--", ".") == 0
+	return b.Git().WithSuccess().Run("diff-index", "--quiet", "HEAD", "--", ".") == 1
 }
 
+// GitVersion returns the v* tags pointing at HEAD, one per line.
 func (b *B) GitVersion() string {
-	buf := &bytes.Buffer{}
-	b.Git().WithOutput(buf).WithSuccess().Run("tag", "-l", "--points-at", "HEAD", `"v*"`)
-	return strings.TrimSpace(buf.String())
+	r := b.gitRepo()
+	if r == nil {
+		return ""
+	}
+	defer r.close()
+	commit, _, err := r.head()
+	if err != nil {
+		b.Debugln("git:", err)
+		return ""
+	}
+	tags, err := r.tags()
+	if err != nil {
+		b.Debugln("git:", err)
+		return ""
+	}
+	var versions []string
+	for name, t := range tags {
+		if t.commit == commit && strings.HasPrefix(name, "v") {
+			versions = append(versions, name)
+		}
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, "\n")
 }
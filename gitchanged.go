@@ -0,0 +1,182 @@
+package building
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangedFiles wraps finding the files changed since a git reference, for
+// instance to only test the packages affected by a change.
+type ChangedFiles struct {
+	base   string
+	staged bool
+	repo   string
+}
+
+// GitChangedFiles handles the files changed since the common ancestor of
+// base and HEAD, or since HEAD if base is empty.
+// Untracked and deleted files are included.
+func (b *B) GitChangedFiles(base string) ChangedFiles {
+	return ChangedFiles{
+		base: base,
+	}
+}
+
+// WithStaged only considers the changes added to the index.
+func (c ChangedFiles) WithStaged() ChangedFiles {
+	c.staged = true
+	return c
+}
+
+// WithRepo looks for changes in the repository in dir instead of the
+// project one, listing the affected packages from dir.
+func (c ChangedFiles) WithRepo(dir string) ChangedFiles {
+	c.repo = dir
+	return c
+}
+
+// Files returns the changed files, relative to the repository root.
+func (c ChangedFiles) Files() []string {
+	base := "HEAD"
+	if c.base != "" {
+		base = strings.Join(c.git("merge-base", c.base, "HEAD"), "")
+	}
+	args := []string{"diff", "--name-only"}
+	if c.staged {
+		args = append(args, "--cached")
+	}
+	files := c.git(append(args, base)...)
+	if !c.staged {
+		files = append(files, c.git("ls-files", "--others", "--exclude-standard", "--full-name", ":/")...)
+	}
+	return unique(files)
+}
+
+// Includes returns the changed files as includes of a fileset, see for
+// instance Copy.WithFileset.
+func (c ChangedFiles) Includes() string {
+	return strings.Join(c.Files(), ",")
+}
+
+// Packages returns the packages among pkgs, defaulting to "./...", which
+// contain changed files or depend on packages that do.
+// Test imports are only followed one level deep.
+func (c ChangedFiles) Packages(pkgs ...string) []string {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	files := c.Files()
+	if len(files) == 0 {
+		return nil
+	}
+	dir := "."
+	tool := b.Go()
+	if c.repo != "" {
+		dir = c.repo
+		tool = tool.WithDir(c.repo)
+	}
+	root, err := importPath(dir)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	// the files are relative to the repository root, make them relative to dir
+	prefix := strings.Join(c.git("rev-parse", "--show-prefix"), "")
+	for i, f := range files {
+		rel, err := filepath.Rel(filepath.FromSlash("/"+prefix), filepath.FromSlash("/"+f))
+		if err != nil {
+			b.Fatalln(err)
+		}
+		files[i] = filepath.ToSlash(rel)
+	}
+	lines, _ := tool.Lines(append([]string{"list", "-f", `{{.ImportPath}} {{join .Deps " "}} {{join .TestImports " "}} {{join .XTestImports " "}}`}, pkgs...)...)
+	return affectedPackages(root, files, lines)
+}
+
+// importPath returns the import path of dir, within its module or else
+// within the project.
+func importPath(dir string) (string, error) {
+	module, moduleDir, err := findModule(dir)
+	if err != nil {
+		return "", err
+	}
+	root, rootDir := b.root, "."
+	if module != "" {
+		root, rootDir = module, moduleDir
+	}
+	rootDir, err = filepath.Abs(rootDir)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(rootDir, dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, filepath.ToSlash(rel)), nil
+}
+
+func (c ChangedFiles) git(args ...string) []string {
+	if c.repo != "" {
+		args = append([]string{"-C", c.repo}, args...)
+	}
+	lines, _ := b.Git().Lines(args...)
+	return lines
+}
+
+// affectedPackages returns the packages whose dependencies, listed on each
+// line after the package itself, contain one of the files relative to the
+// folder of the root package.
+// A file, even deleted, belongs to the package of its closest parent folder.
+func affectedPackages(root string, files, lines []string) []string {
+	deps := map[string][]string{}
+	var pkgs []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pkgs = append(pkgs, fields[0])
+		deps[fields[0]] = fields
+	}
+	changed := map[string]bool{}
+	for _, f := range files {
+		for dir := path.Dir(f); ; dir = path.Dir(dir) {
+			pkg := path.Join(root, dir)
+			if _, ok := deps[pkg]; ok {
+				changed[pkg] = true
+				break
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	var affected []string
+	for _, pkg := range pkgs {
+		for _, dep := range deps[pkg] {
+			if changed[dep] {
+				affected = append(affected, pkg)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+func unique(s []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,77 @@
+package building
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestGitChangedFiles(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	defer useBuilder()()
+	git(t, dir.Path(), "checkout", "-q", "-b", "feature", "HEAD~1")
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir.Path(), "sub"), 0755))
+	for _, f := range []string{"committed", "staged", "modified", "sub/untracked", "deleted"} {
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir.Path(), f), []byte(f), 0644))
+	}
+	git(t, dir.Path(), "add", "committed", "modified", "deleted")
+	git(t, dir.Path(), "commit", "-q", "-m", "feature")
+	git(t, dir.Path(), "rm", "-q", "deleted")
+	git(t, dir.Path(), "add", "staged")
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir.Path(), "modified"), []byte("changed"), 0644))
+	assert.NilError(t, os.Remove(filepath.Join(dir.Path(), "file")))
+
+	changes := b.GitChangedFiles("master").WithRepo(dir.Path())
+	assert.DeepEqual(t, changes.Files(), []string{"committed", "file", "modified", "staged", "sub/untracked"})
+	assert.Equal(t, changes.Includes(), "committed,file,modified,staged,sub/untracked")
+	assert.DeepEqual(t, changes.WithRepo(filepath.Join(dir.Path(), "sub")).Files(), changes.Files())
+	assert.DeepEqual(t, b.GitChangedFiles("").WithRepo(dir.Path()).Files(), []string{"deleted", "file", "modified", "staged", "sub/untracked"})
+	assert.DeepEqual(t, b.GitChangedFiles("").WithRepo(dir.Path()).WithStaged().Files(), []string{"deleted", "staged"})
+}
+
+func TestAffectedPackages(t *testing.T) {
+	lines := []string{
+		"example.com/a fmt example.com/a/b example.com/a/c",
+		"example.com/a/b fmt",
+		"example.com/a/c",
+		"example.com/a/d   example.com/a/c",
+		"example.com/a/e",
+	}
+	assert.DeepEqual(t, affectedPackages("example.com/a", []string{"b/b.go"}, lines),
+		[]string{"example.com/a", "example.com/a/b"})
+	assert.DeepEqual(t, affectedPackages("example.com/a", []string{"c/testdata/in.txt"}, lines),
+		[]string{"example.com/a", "example.com/a/c", "example.com/a/d"})
+	assert.DeepEqual(t, affectedPackages("example.com/a", []string{"b/deleted.go"}, lines),
+		[]string{"example.com/a", "example.com/a/b"})
+	assert.DeepEqual(t, affectedPackages("example.com/a", []string{"e/e.go", "README.md"}, lines),
+		[]string{"example.com/a", "example.com/a/e"})
+	assert.Equal(t, len(affectedPackages("example.com/a", []string{"docs/a.md"}, lines[1:])), 0)
+	assert.DeepEqual(t, affectedPackages("example.com/a/d", []string{"../c/c.go"}, lines),
+		[]string{"example.com/a", "example.com/a/c", "example.com/a/d"})
+}
+
+func TestImportPath(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithDir(".git"),
+		fs.WithDir("mod",
+			fs.WithFile("go.mod", "module example.com/mod\n"),
+			fs.WithDir("sub")))
+	defer rootDirectory.Remove()
+	defer useBuilder()()
+	b.root = "example.com/project"
+
+	p, err := importPath(filepath.Join(rootDirectory.Path(), "mod", "sub"))
+	assert.NilError(t, err)
+	assert.Equal(t, p, "example.com/mod/sub")
+	p, err = importPath(filepath.Join(rootDirectory.Path(), "mod"))
+	assert.NilError(t, err)
+	assert.Equal(t, p, "example.com/mod")
+	p, err = importPath(".")
+	assert.NilError(t, err)
+	assert.Equal(t, p, "example.com/project")
+}
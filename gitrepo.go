@@ -0,0 +1,656 @@
+package building
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gitRepo reads the metadata of a git repository directly from its .git
+// folder, supporting loose and packed objects and references.
+type gitRepo struct {
+	// dir holds HEAD, common holds the objects and references, they differ
+	// for worktrees.
+	dir    string
+	common string
+	packs  []*gitPack
+	// tagged and commits cache the tags and the parents of the commits
+	// read so far.
+	tagged  map[string]gitTag
+	commits map[string][]string
+}
+
+// openGit opens the repository containing dir.
+func openGit(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		git := filepath.Join(dir, ".git")
+		info, err := os.Stat(git)
+		if err == nil {
+			if !info.IsDir() {
+				// worktrees and submodules use a file pointing to the
+				// actual folder
+				if git, err = readGitFile(git); err != nil {
+					return nil, err
+				}
+			}
+			return newGitRepo(git)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not a git repository")
+		}
+		dir = parent
+	}
+}
+
+func readGitFile(file string) (string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(s, "gitdir: ") {
+		return "", fmt.Errorf("invalid git file %s", file)
+	}
+	dir := strings.TrimPrefix(s, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(file), dir)
+	}
+	return dir, nil
+}
+
+func newGitRepo(dir string) (*gitRepo, error) {
+	r := &gitRepo{dir: dir, common: dir}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		r.common = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(r.common) {
+			r.common = filepath.Join(dir, r.common)
+		}
+	}
+	idxs, err := filepath.Glob(filepath.Join(r.common, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		p, err := openPack(idx)
+		if err != nil {
+			return nil, err
+		}
+		r.packs = append(r.packs, p)
+	}
+	return r, nil
+}
+
+// close closes the pack files opened while reading objects.
+func (r *gitRepo) close() {
+	for _, p := range r.packs {
+		if p.file != nil {
+			b.Close(p.file)
+			p.file = nil
+		}
+	}
+}
+
+// head returns the commit checked out and the branch, empty if detached.
+func (r *gitRepo) head() (string, string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(r.dir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+	head := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(head, "ref: ") {
+		return head, "", nil
+	}
+	ref := strings.TrimPrefix(head, "ref: ")
+	hash, err := r.resolve(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return hash, strings.TrimPrefix(ref, "refs/heads/"), nil
+}
+
+// remote returns the url of a remote from the configuration.
+func (r *gitRepo) remote(name string) (string, error) {
+	f, err := os.Open(filepath.Join(r.common, "config"))
+	if err != nil {
+		return "", err
+	}
+	defer b.Close(f)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if section != `remote "`+name+`"` {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "url" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("unknown remote %s", name)
+}
+
+// resolve returns the hash a reference points to.
+func (r *gitRepo) resolve(ref string) (string, error) {
+	for i := 0; i < 10; i++ {
+		content, err := ioutil.ReadFile(filepath.Join(r.common, filepath.FromSlash(ref)))
+		if os.IsNotExist(err) {
+			refs, err := r.packedRefs()
+			if err != nil {
+				return "", err
+			}
+			if hash, ok := refs[ref]; ok {
+				return hash.hash, nil
+			}
+			return "", fmt.Errorf("unknown reference %s", ref)
+		}
+		if err != nil {
+			return "", err
+		}
+		s := strings.TrimSpace(string(content))
+		if !strings.HasPrefix(s, "ref: ") {
+			return s, nil
+		}
+		ref = strings.TrimPrefix(s, "ref: ")
+	}
+	return "", fmt.Errorf("too many levels of symbolic references")
+}
+
+type gitRef struct {
+	hash string
+	// peeled is the commit an annotated tag points to, if known
+	peeled string
+}
+
+func (r *gitRepo) packedRefs() (map[string]gitRef, error) {
+	refs := map[string]gitRef{}
+	f, err := os.Open(filepath.Join(r.common, "packed-refs"))
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close(f)
+	last := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "^") {
+			ref := refs[last]
+			ref.peeled = line[1:]
+			refs[last] = ref
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid packed-refs line %q", line)
+		}
+		last = fields[1]
+		refs[last] = gitRef{hash: fields[0]}
+	}
+	return refs, scanner.Err()
+}
+
+// tags returns the commits of the tags by name, annotated tags being
+// peeled.
+func (r *gitRepo) tags() (map[string]gitTag, error) {
+	if r.tagged != nil {
+		return r.tagged, nil
+	}
+	refs, err := r.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]gitTag{}
+	for ref, h := range refs {
+		if !strings.HasPrefix(ref, "refs/tags/") {
+			continue
+		}
+		name := strings.TrimPrefix(ref, "refs/tags/")
+		if h.peeled != "" {
+			tags[name] = gitTag{commit: h.peeled, annotated: true}
+			continue
+		}
+		if tags[name], err = r.tag(h.hash); err != nil {
+			return nil, err
+		}
+	}
+	root := filepath.Join(r.common, "refs", "tags")
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		tags[filepath.ToSlash(rel)], err = r.tag(strings.TrimSpace(string(content)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.tagged = tags
+	return tags, nil
+}
+
+type gitTag struct {
+	commit    string
+	annotated bool
+}
+
+// tag peels hash down to a commit.
+func (r *gitRepo) tag(hash string) (gitTag, error) {
+	t := gitTag{commit: hash}
+	for i := 0; i < 10; i++ {
+		typ, data, err := r.object(t.commit)
+		if err != nil {
+			return t, err
+		}
+		if typ != "tag" {
+			return t, nil
+		}
+		t.annotated = true
+		t.commit = header(data, "object")
+	}
+	return t, fmt.Errorf("too many levels of tags for %s", hash)
+}
+
+// parents returns the parents of a commit.
+func (r *gitRepo) parents(commit string) ([]string, error) {
+	if parents, ok := r.commits[commit]; ok {
+		return parents, nil
+	}
+	typ, data, err := r.object(commit)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "commit" {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", commit, typ)
+	}
+	var parents []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "parent ") {
+			parents = append(parents, strings.TrimPrefix(line, "parent "))
+		}
+	}
+	if r.commits == nil {
+		r.commits = map[string][]string{}
+	}
+	r.commits[commit] = parents
+	return parents, nil
+}
+
+// header returns the value of a header line of a commit or tag.
+func header(data []byte, key string) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, key+" ") {
+			return strings.TrimPrefix(line, key+" ")
+		}
+	}
+	return ""
+}
+
+// object returns the type and content of an object.
+func (r *gitRepo) object(hash string) (string, []byte, error) {
+	if len(hash) != 40 {
+		return "", nil, fmt.Errorf("invalid object %q", hash)
+	}
+	f, err := os.Open(filepath.Join(r.common, "objects", hash[:2], hash[2:]))
+	if os.IsNotExist(err) {
+		id, err := hex.DecodeString(hash)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, p := range r.packs {
+			if offset, ok := p.find(id); ok {
+				return p.object(r, offset)
+			}
+		}
+		return "", nil, &missingObject{hash: hash}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer b.Close(f)
+	z, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, err
+	}
+	i := bytes.IndexByte(content, 0)
+	if i == -1 {
+		return "", nil, fmt.Errorf("invalid object %s", hash)
+	}
+	return strings.Fields(string(content[:i]))[0], content[i+1:], nil
+}
+
+type missingObject struct {
+	hash string
+}
+
+func (e *missingObject) Error() string {
+	return fmt.Sprintf("object %s not found", e.hash)
+}
+
+// describe returns the closest tag matching pattern reachable from commit
+// and the number of commits since, or an empty tag if none.
+// Only annotated tags are considered unless all is set, like git describe.
+func (r *gitRepo) describe(commit, pattern string, all bool) (string, int, error) {
+	return r.describeFunc(commit, all, func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return pattern == "" || ok
+	})
+}
+
+// describeFunc is describe for the tags accepted by match.
+func (r *gitRepo) describeFunc(commit string, all bool, match func(name string) bool) (string, int, error) {
+	tags, err := r.tags()
+	if err != nil {
+		return "", 0, err
+	}
+	byCommit := map[string][]string{}
+	for name, t := range tags {
+		if !t.annotated && !all {
+			continue
+		}
+		if match(name) {
+			byCommit[t.commit] = append(byCommit[t.commit], name)
+		}
+	}
+	ancestors, err := r.ancestors(commit, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	best, distance, candidates := "", 0, 0
+	for _, c := range ancestors {
+		names := byCommit[c]
+		if len(names) == 0 {
+			continue
+		}
+		// like git, only the closest candidates are compared
+		if candidates++; candidates > 10 {
+			break
+		}
+		excluded, err := r.ancestors(c, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		d := len(ancestors) - len(excluded)
+		if best == "" || d < distance {
+			sort.Strings(names)
+			best, distance = names[len(names)-1], d
+		}
+		if d == 0 {
+			break
+		}
+	}
+	return best, distance, nil
+}
+
+// ancestors returns commit and all its ancestors, closest first.
+// A missing commit ends the history, as in a shallow clone.
+func (r *gitRepo) ancestors(commit string, seen map[string]bool) ([]string, error) {
+	if seen == nil {
+		seen = map[string]bool{}
+	}
+	var commits []string
+	queue := []string{commit}
+	seen[commit] = true
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		commits = append(commits, c)
+		parents, err := r.parents(c)
+		if _, ok := err.(*missingObject); ok && c != commit {
+			b.Debugln("git: history ends at missing commit", c)
+			commits = commits[:len(commits)-1]
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return commits, nil
+}
+
+// gitPack is a pack file along with its version 2 index.
+type gitPack struct {
+	path    string
+	file    *os.File
+	fanout  [256]uint32
+	names   []byte
+	offsets []byte
+	large   []byte
+}
+
+func openPack(idx string) (*gitPack, error) {
+	content, err := ioutil.ReadFile(idx)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 8+256*4 || !bytes.Equal(content[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(content[4:8]) != 2 {
+		return nil, fmt.Errorf("unsupported pack index %s", idx)
+	}
+	p := &gitPack{path: strings.TrimSuffix(idx, ".idx") + ".pack"}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(content[8+i*4:])
+	}
+	n := int(p.fanout[255])
+	start := 8 + 256*4
+	if len(content) < start+n*(20+4+4) {
+		return nil, fmt.Errorf("truncated pack index %s", idx)
+	}
+	p.names = content[start : start+n*20]
+	start += n * (20 + 4)
+	p.offsets = content[start : start+n*4]
+	p.large = content[start+n*4:]
+	return p, nil
+}
+
+// find returns the offset of an object in the pack.
+func (p *gitPack) find(id []byte) (int64, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.names[(lo+i)*20:(lo+i+1)*20], id) >= 0
+	})
+	if i == hi || !bytes.Equal(p.names[i*20:(i+1)*20], id) {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	j := int(offset &^ 0x80000000)
+	return int64(binary.BigEndian.Uint64(p.large[j*8:])), true
+}
+
+var packTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+// object reads the object at offset, resolving deltas, and keeps the pack
+// file open until the repository gets closed.
+func (p *gitPack) object(r *gitRepo, offset int64) (string, []byte, error) {
+	if p.file == nil {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return "", nil, err
+		}
+		p.file = f
+	}
+	br := bufio.NewReader(io.NewSectionReader(p.file, offset, 1<<62))
+	c, err := br.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	typ := (c >> 4) & 7
+	for c&0x80 != 0 {
+		if c, err = br.ReadByte(); err != nil {
+			return "", nil, err
+		}
+	}
+	var baseType string
+	var base []byte
+	switch typ {
+	case 6:
+		// the base is at a relative offset encoded with an odd varint
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return "", nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+		if baseType, base, err = p.object(r, offset-rel); err != nil {
+			return "", nil, err
+		}
+	case 7:
+		id := make([]byte, 20)
+		if _, err := io.ReadFull(br, id); err != nil {
+			return "", nil, err
+		}
+		if baseType, base, err = r.object(hex.EncodeToString(id)); err != nil {
+			return "", nil, err
+		}
+	}
+	z, err := zlib.NewReader(br)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, err
+	}
+	if base == nil {
+		if packTypes[typ] == "" {
+			return "", nil, fmt.Errorf("unsupported object type %d in %s", typ, p.path)
+		}
+		return packTypes[typ], data, nil
+	}
+	data, err = applyDelta(base, data)
+	return baseType, data, err
+}
+
+// applyDelta rebuilds an object from its base and a delta made of copy and
+// insert instructions.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	invalid := fmt.Errorf("invalid delta")
+	varint := func() (int, bool) {
+		n, shift := 0, uint(0)
+		for len(delta) > 0 {
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				return n, true
+			}
+		}
+		return 0, false
+	}
+	if size, ok := varint(); !ok || size != len(base) {
+		return nil, invalid
+	}
+	size, ok := varint()
+	if !ok {
+		return nil, invalid
+	}
+	result := make([]byte, 0, size)
+	for len(delta) > 0 {
+		c := delta[0]
+		delta = delta[1:]
+		if c&0x80 == 0 {
+			n := int(c)
+			if n == 0 || n > len(delta) {
+				return nil, invalid
+			}
+			result = append(result, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+		offset, n := 0, 0
+		for i := uint(0); i < 7; i++ {
+			if c&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, invalid
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				n |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		if offset+n > len(base) {
+			return nil, invalid
+		}
+		result = append(result, base[offset:offset+n]...)
+	}
+	if len(result) != size {
+		return nil, invalid
+	}
+	return result, nil
+}
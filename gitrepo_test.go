@@ -0,0 +1,174 @@
+package building
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+// makeGitRepo creates a repository with an annotated tag, a lightweight
+// tag and a file modified at each commit.
+func makeGitRepo(t *testing.T) *fs.Dir {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := fs.NewDir(t, "repo")
+	git(t, dir.Path(), "init", "-q")
+	content := strings.Repeat("some content to be deltified\n", 100)
+	for i, tag := range []string{"-a v1.0.0 -m release", "light", ""} {
+		content += strings.Repeat("x", i) + "\n"
+		assert.NilError(t, ioutil.WriteFile(filepath.Join(dir.Path(), "file"), []byte(content), 0644))
+		git(t, dir.Path(), "add", "file")
+		git(t, dir.Path(), "commit", "-q", "-m", "commit")
+		if tag != "" {
+			git(t, dir.Path(), append([]string{"tag"}, strings.Fields(tag)...)...)
+		}
+	}
+	return dir
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir,
+		"-c", "user.name=test", "-c", "user.email=test@example.com",
+		"-c", "init.defaultBranch=master", "-c", "tag.gpgSign=false", "-c", "commit.gpgSign=false"}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitRepo(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	checkGitRepo(t, dir.Path())
+}
+
+func TestGitRepoPacked(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	git(t, dir.Path(), "gc", "-q", "--aggressive")
+	checkGitRepo(t, dir.Path())
+}
+
+func checkGitRepo(t *testing.T, dir string) {
+	t.Helper()
+	r, err := openGit(dir)
+	assert.NilError(t, err)
+	commit, branch, err := r.head()
+	assert.NilError(t, err)
+	assert.Equal(t, commit, git(t, dir, "rev-parse", "HEAD"))
+	assert.Equal(t, branch, git(t, dir, "rev-parse", "--abbrev-ref", "HEAD"))
+
+	tag, distance, err := r.describe(commit, "", false)
+	assert.NilError(t, err)
+	assert.Equal(t, formatDescribe(tag, distance, commit), git(t, dir, "describe", "--always"))
+	tag, distance, err = r.describe(commit, "", true)
+	assert.NilError(t, err)
+	assert.Equal(t, formatDescribe(tag, distance, commit), git(t, dir, "describe", "--always", "--tags"))
+	tag, _, err = r.describe(commit, "w*", true)
+	assert.NilError(t, err)
+	assert.Equal(t, tag, "")
+
+	tags, err := r.tags()
+	assert.NilError(t, err)
+	assert.Equal(t, len(tags), 2)
+	assert.Equal(t, tags["v1.0.0"].commit, git(t, dir, "rev-parse", "v1.0.0^{commit}"))
+	assert.Assert(t, tags["v1.0.0"].annotated)
+	assert.Equal(t, tags["light"].commit, git(t, dir, "rev-parse", "light"))
+	assert.Assert(t, !tags["light"].annotated)
+
+	for _, rev := range []string{"HEAD:file", "HEAD~1:file", "HEAD~2:file"} {
+		typ, data, err := r.object(git(t, dir, "rev-parse", rev))
+		assert.NilError(t, err)
+		assert.Equal(t, typ, "blob")
+		assert.Equal(t, strings.TrimSpace(string(data)), git(t, dir, "cat-file", "-p", rev))
+	}
+}
+
+func TestGitRepoWorktree(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	worktree := filepath.Join(dir.Path(), "worktree")
+	git(t, dir.Path(), "worktree", "add", "-q", "-b", "feature", worktree, "HEAD~1")
+
+	r, err := openGit(worktree)
+	assert.NilError(t, err)
+	commit, branch, err := r.head()
+	assert.NilError(t, err)
+	assert.Equal(t, commit, git(t, dir.Path(), "rev-parse", "HEAD~1"))
+	assert.Equal(t, branch, "feature")
+}
+
+func TestGitRepoDetached(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	git(t, dir.Path(), "checkout", "-q", "v1.0.0")
+
+	r, err := openGit(dir.Path())
+	assert.NilError(t, err)
+	commit, branch, err := r.head()
+	assert.NilError(t, err)
+	assert.Equal(t, commit, git(t, dir.Path(), "rev-parse", "HEAD"))
+	assert.Equal(t, branch, "")
+	tag, distance, err := r.describe(commit, "v*", false)
+	assert.NilError(t, err)
+	assert.Equal(t, tag, "v1.0.0")
+	assert.Equal(t, distance, 0)
+}
+
+func TestGitRepoShallow(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	shallow := filepath.Join(dir.Path(), "shallow")
+	git(t, dir.Path(), "clone", "-q", "--depth", "1", "file://"+dir.Path(), shallow)
+
+	r, err := openGit(shallow)
+	assert.NilError(t, err)
+	defer r.close()
+	commit, _, err := r.head()
+	assert.NilError(t, err)
+	tag, distance, err := r.describe(commit, "", true)
+	assert.NilError(t, err)
+	assert.Equal(t, tag, "")
+	assert.Equal(t, formatDescribe(tag, distance, commit), git(t, shallow, "describe", "--always", "--tags"))
+}
+
+func TestNotGitRepo(t *testing.T) {
+	dir := fs.NewDir(t, "root")
+	defer dir.Remove()
+	_, err := openGit(dir.Path())
+	assert.Error(t, err, "not a git repository")
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	// base size 11, result size 12, copy 6 bytes at 0, insert "there!"
+	delta := []byte{11, 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '!'}
+	result, err := applyDelta(base, delta)
+	assert.NilError(t, err)
+	assert.Equal(t, string(result), "hello there!")
+	_, err = applyDelta(base, []byte{10, 12})
+	assert.Error(t, err, "invalid delta")
+}
+
+func TestGitDirty(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	defer useBuilder()()
+	wd, err := os.Getwd()
+	assert.NilError(t, err)
+	defer os.Chdir(wd)
+	assert.NilError(t, os.Chdir(dir.Path()))
+
+	assert.Assert(t, !b.GitDirty())
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir.Path(), "file"), []byte("modified\n"), 0644))
+	assert.Assert(t, b.GitDirty())
+}
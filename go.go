@@ -1,13 +1,53 @@
 package building
 
-var GoVersion = "1.10.3"
+var (
+	GoVersion = "1.10.3"
+	// GoConstraint is the version required from a locally installed go,
+	// otherwise GoVersion runs in a container.
+	GoConstraint = ">=1.10"
+	// GoModulesVersion and GoModulesConstraint replace GoVersion and
+	// GoConstraint for projects using Go modules.
+	GoModulesVersion    = "1.11.4"
+	GoModulesConstraint = ">=1.11"
+)
 
 // $$$$ MAT go verbose with -v ?
 func (b *B) Go(args ...string) Tool {
-	return b.MakeTool(
+	version, constraint := b.goImage()
+	t := b.MakeTool(
 		"go",
 		"version",
 		"http://golang.org",
-		"FROM golang:"+GoVersion+"-alpine"+AlpineVersion,
-		args...)
+		"FROM golang:"+version+"-alpine"+AlpineVersion).
+		WithVersion(constraint, nil)
+	if len(args) > 0 {
+		t.Run(args...)
+	}
+	return t
+}
+
+// goImage returns the version of go running in a container and the
+// constraint for a locally installed one.
+func (b *B) goImage() (string, string) {
+	if b != nil && b.module {
+		return GoModulesVersion, GoModulesConstraint
+	}
+	return GoVersion, GoConstraint
+}
+
+// goVersion returns the version of go used by the build, either installed
+// locally or running in a container.
+func (b *B) goVersion() string {
+	t := b.Go().detect()
+	if t.container {
+		version, _ := b.goImage()
+		return version
+	}
+	out, _ := t.WithSuccess().Output("version")
+	return extractVersion(out)
+}
+
+// goSupports tells whether the go used by the build is at least version.
+func (b *B) goSupports(version string) bool {
+	return compareVersions(b.goVersion(), version) >= 0
 }
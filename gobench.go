@@ -0,0 +1,333 @@
+package building
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// GoBench wraps running Go benchmarks and comparing them to a baseline.
+type GoBench struct {
+	pkgs      []string
+	bench     string
+	count     int
+	output    string
+	baseline  string
+	threshold float64
+	alpha     float64
+	flags     []string
+	summary   io.Writer
+}
+
+// GoBench handles benchmarking Go packages, defaulting to "./...".
+func (b *B) GoBench(pkgs ...string) GoBench {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return GoBench{
+		pkgs:      pkgs,
+		bench:     ".",
+		count:     10,
+		output:    "bench.txt",
+		threshold: 5,
+		alpha:     0.05,
+	}
+}
+
+// WithBench only runs the benchmarks matching pattern.
+func (g GoBench) WithBench(pattern string) GoBench {
+	g.bench = pattern
+	return g
+}
+
+// WithCount sets how many times each benchmark runs, defaults to 10.
+func (g GoBench) WithCount(n int) GoBench {
+	g.count = n
+	return g
+}
+
+// WithOutput sets the file receiving the results, defaults to "bench.txt".
+func (g GoBench) WithOutput(path string) GoBench {
+	g.output = path
+	return g
+}
+
+// WithBaseline compares the results to those saved in path, if it exists.
+func (g GoBench) WithBaseline(path string) GoBench {
+	g.baseline = path
+	return g
+}
+
+// WithThreshold fails on significant regressions above percent, defaults
+// to 5.
+func (g GoBench) WithThreshold(percent float64) GoBench {
+	g.threshold = percent
+	return g
+}
+
+// WithFlags adds flags to go test.
+func (g GoBench) WithFlags(flags ...string) GoBench {
+	g.flags = append(g.flags, flags...)
+	return g
+}
+
+// WithSummary sets where the comparison gets written, defaults to stdout.
+func (g GoBench) WithSummary(w io.Writer) GoBench {
+	g.summary = w
+	return g
+}
+
+// Run runs the benchmarks, saves the results and compares them to the
+// baseline.
+func (g GoBench) Run() {
+	if g.summary == nil {
+		g.summary = os.Stdout
+	}
+	// the baseline is read first as it may be the output of a previous run
+	old := g.readBaseline()
+	args := append([]string{"test", "-run", "^$", "-bench", g.bench, "-count", strconv.Itoa(g.count)}, g.flags...)
+	out, _ := b.Go().Output(append(args, g.pkgs...)...)
+	if err := os.MkdirAll(filepath.Dir(g.output), 0755); err != nil {
+		b.Fatalln(err)
+	}
+	if err := ioutil.WriteFile(g.output, []byte(out+"\n"), 0644); err != nil {
+		b.Fatalln(err)
+	}
+	if old == nil {
+		fmt.Fprintln(g.summary, out)
+		return
+	}
+	results, err := parseBenchmarks(strings.NewReader(out))
+	if err != nil {
+		b.Fatalln(err)
+	}
+	if err := g.compare(compareBenchmarks(old, results, g.alpha)); err != nil {
+		b.Fatalln(err)
+	}
+}
+
+// readBaseline returns the results of the baseline, nil if not set or
+// missing.
+func (g GoBench) readBaseline() benchmarks {
+	if g.baseline == "" {
+		return nil
+	}
+	f, err := os.Open(g.baseline)
+	if os.IsNotExist(err) {
+		b.Println("no baseline", g.baseline, "to compare to")
+		return nil
+	}
+	if err != nil {
+		b.Fatalln(err)
+	}
+	defer b.Close(f)
+	old, err := parseBenchmarks(f)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	return old
+}
+
+func (g GoBench) compare(deltas []benchDelta) error {
+	w := tabwriter.NewWriter(g.summary, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "name\tunit\told\tnew\tdelta")
+	var regressions []string
+	for _, d := range deltas {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.name, d.unit, formatValue(d.old), formatValue(d.new), d)
+		if d.significant() && d.worse() > g.threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %s %s", d.name, d.unit, d))
+		}
+	}
+	b.Check(w.Flush())
+	if len(regressions) > 0 {
+		return fmt.Errorf("benchmark regressions above %g%%:\n%s", g.threshold, strings.Join(regressions, "\n"))
+	}
+	return nil
+}
+
+// benchmarks holds the samples of benchmarks by name and unit.
+type benchmarks map[string]map[string][]float64
+
+var cpuSuffix = regexp.MustCompile(`-\d+$`)
+
+// parseBenchmarks reads the output of go test -bench, benchmarks being
+// prefixed with their package.
+func parseBenchmarks(r io.Reader) (benchmarks, error) {
+	results := benchmarks{}
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "pkg: ") {
+			pkg = strings.TrimPrefix(line, "pkg: ")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := cpuSuffix.ReplaceAllString(fields[0], "")
+		if pkg != "" {
+			name = pkg + "." + name
+		}
+		if results[name] == nil {
+			results[name] = map[string][]float64{}
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid benchmark line %q", line)
+			}
+			results[name][fields[i+1]] = append(results[name][fields[i+1]], v)
+		}
+	}
+	return results, scanner.Err()
+}
+
+// benchDelta is the difference between the medians of two samples.
+type benchDelta struct {
+	name string
+	unit string
+	old  float64
+	new  float64
+	p    float64
+	// alpha is the significance level
+	alpha float64
+	n     [2]int
+}
+
+func compareBenchmarks(old, new benchmarks, alpha float64) []benchDelta {
+	var deltas []benchDelta
+	for name, units := range new {
+		for unit, samples := range units {
+			before, ok := old[name][unit]
+			if !ok {
+				continue
+			}
+			deltas = append(deltas, benchDelta{
+				name:  name,
+				unit:  unit,
+				old:   median(before),
+				new:   median(samples),
+				p:     mannWhitney(before, samples),
+				alpha: alpha,
+				n:     [2]int{len(before), len(samples)},
+			})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].name != deltas[j].name {
+			return deltas[i].name < deltas[j].name
+		}
+		return deltas[i].unit < deltas[j].unit
+	})
+	return deltas
+}
+
+// significant returns true if the difference is unlikely to be noise.
+func (d benchDelta) significant() bool {
+	return d.p < d.alpha
+}
+
+// percent returns the relative difference in percent.
+func (d benchDelta) percent() float64 {
+	if d.old == 0 {
+		return 0
+	}
+	return (d.new - d.old) * 100 / d.old
+}
+
+// worse returns how much worse the new value is in percent, throughputs
+// being better when higher.
+func (d benchDelta) worse() float64 {
+	if strings.HasSuffix(d.unit, "/s") {
+		return -d.percent()
+	}
+	return d.percent()
+}
+
+func (d benchDelta) String() string {
+	if !d.significant() {
+		return fmt.Sprintf("~ (p=%.3f n=%d+%d)", d.p, d.n[0], d.n[1])
+	}
+	return fmt.Sprintf("%+.2f%% (p=%.3f n=%d+%d)", d.percent(), d.p, d.n[0], d.n[1])
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func median(samples []float64) float64 {
+	s := append([]float64(nil), samples...)
+	sort.Float64s(s)
+	n := len(s)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test of
+// the samples, using the normal approximation with ties correction.
+func mannWhitney(x, y []float64) float64 {
+	n1, n2 := float64(len(x)), float64(len(y))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		v     float64
+		first bool
+	}
+	var all []sample
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	n := n1 + n2
+	r1, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		// tied values share the average of their ranks
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				r1 += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := r1 - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
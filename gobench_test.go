@@ -0,0 +1,75 @@
+package building
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const benchOutput = `goos: linux
+goarch: amd64
+pkg: example.com/a
+BenchmarkFoo-8   	 1000000	      1000 ns/op	      16 B/op	       1 allocs/op
+BenchmarkFoo-8   	 1000000	      1010 ns/op	      16 B/op	       1 allocs/op
+BenchmarkFoo-8   	 1000000	       990 ns/op	      16 B/op	       1 allocs/op
+BenchmarkBar-8   	     100	  20000000 ns/op	 100.00 MB/s
+PASS
+ok  	example.com/a	3.2s
+`
+
+func TestParseBenchmarks(t *testing.T) {
+	results, err := parseBenchmarks(strings.NewReader(benchOutput))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, results, benchmarks{
+		"example.com/a.BenchmarkFoo": {
+			"ns/op":     {1000, 1010, 990},
+			"B/op":      {16, 16, 16},
+			"allocs/op": {1, 1, 1},
+		},
+		"example.com/a.BenchmarkBar": {
+			"ns/op": {20000000},
+			"MB/s":  {100},
+		},
+	})
+}
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, median([]float64{3, 1, 2}), float64(2))
+	assert.Equal(t, median([]float64{4, 1, 3, 2}), 2.5)
+	assert.Equal(t, median(nil), float64(0))
+}
+
+func TestMannWhitney(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	y := []float64{11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	assert.Assert(t, mannWhitney(x, y) < 0.001)
+	assert.Assert(t, mannWhitney(x, x) > 0.9)
+	assert.Equal(t, mannWhitney([]float64{1, 1}, []float64{1, 1}), float64(1))
+	assert.Equal(t, mannWhitney(x, nil), float64(1))
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	old := benchmarks{
+		"a.BenchmarkSlower": {"ns/op": {100, 101, 99, 100, 102, 98, 100, 101}},
+		"a.BenchmarkNoise":  {"ns/op": {100, 110, 90, 105, 95, 100, 108, 92}},
+		"a.BenchmarkFaster": {"MB/s": {100, 101, 99, 100, 102, 98, 100, 101}},
+		"a.BenchmarkGone":   {"ns/op": {100}},
+	}
+	new := benchmarks{
+		"a.BenchmarkSlower": {"ns/op": {120, 121, 119, 120, 122, 118, 120, 121}},
+		"a.BenchmarkNoise":  {"ns/op": {101, 111, 91, 104, 96, 99, 109, 93}},
+		"a.BenchmarkFaster": {"MB/s": {120, 121, 119, 120, 122, 118, 120, 121}},
+		"a.BenchmarkNew":    {"ns/op": {100}},
+	}
+	buf := &bytes.Buffer{}
+	deltas := compareBenchmarks(old, new, 0.05)
+	assert.Equal(t, len(deltas), 3)
+	err := b.GoBench().WithSummary(buf).compare(deltas)
+	assert.ErrorContains(t, err, "benchmark regressions above 5%:\na.BenchmarkSlower ns/op +20.00%")
+	assert.Assert(t, !strings.Contains(err.Error(), "Faster"))
+	assert.Assert(t, !strings.Contains(err.Error(), "Noise"))
+	assert.Assert(t, strings.Contains(buf.String(), "a.BenchmarkNoise   ns/op  100  100  ~"), buf.String())
+	assert.NilError(t, b.GoBench().WithThreshold(25).WithSummary(buf).compare(deltas))
+}
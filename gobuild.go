@@ -0,0 +1,178 @@
+package building
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// GoBuild wraps a go build with version injection and cross-compilation.
+type GoBuild struct {
+	pkg        string
+	output     string
+	name       string
+	versionVar string
+	version    string
+	platforms  []string
+	trimpath   bool
+	// gopath is trimmed from the binaries by go versions older than 1.13
+	// lacking -trimpath.
+	gopath string
+	cgo    *bool
+	flags  []string
+}
+
+// GoBuild handles building a Go package for one or several platforms.
+func (b *B) GoBuild(pkg string) GoBuild {
+	name := path.Base(pkg)
+	if name == "." {
+		name = path.Base(b.root)
+	}
+	return GoBuild{
+		pkg:  pkg,
+		name: name,
+	}
+}
+
+// WithOutput sets the folder where the binaries are written, defaults to the
+// current folder.
+func (g GoBuild) WithOutput(dir string) GoBuild {
+	g.output = dir
+	return g
+}
+
+// WithName sets the base name of the binaries, defaults to the package base
+// name.
+func (g GoBuild) WithName(name string) GoBuild {
+	g.name = name
+	return g
+}
+
+// WithVersionVar injects the version from git into a string variable, e.g.
+// "main.version".
+func (g GoBuild) WithVersionVar(name string) GoBuild {
+	g.versionVar = name
+	return g
+}
+
+// WithVersion overrides the version injected with WithVersionVar.
+func (g GoBuild) WithVersion(version string) GoBuild {
+	g.version = version
+	return g
+}
+
+// WithPlatforms sets the platforms as "os/arch", e.g. "linux/amd64", built
+// only with -cross, otherwise only the current platform gets built.
+func (g GoBuild) WithPlatforms(platforms ...string) GoBuild {
+	g.platforms = append(g.platforms, platforms...)
+	return g
+}
+
+// WithTrimpath removes file system paths from the binaries, only trimming
+// GOPATH with go versions older than 1.13.
+func (g GoBuild) WithTrimpath() GoBuild {
+	g.trimpath = true
+	return g
+}
+
+// WithCGO enables or disables cgo.
+func (g GoBuild) WithCGO(enabled bool) GoBuild {
+	g.cgo = &enabled
+	return g
+}
+
+// WithFlags adds flags to go build.
+func (g GoBuild) WithFlags(flags ...string) GoBuild {
+	g.flags = append(g.flags, flags...)
+	return g
+}
+
+// Run builds the binaries and returns their paths.
+func (g GoBuild) Run() []string {
+	if g.versionVar != "" && g.version == "" {
+		g.version = b.GitTag()
+	}
+	if g.trimpath && !b.goSupports("1.13") {
+		gopath, _ := b.Go().Output("env", "GOPATH")
+		if paths := filepath.SplitList(gopath); len(paths) > 0 {
+			g.gopath = paths[0]
+		}
+	}
+	platforms := g.targets()
+	outputs := make([]string, len(platforms))
+	wg := sync.WaitGroup{}
+	for i, platform := range platforms {
+		goos, goarch := splitPlatform(platform)
+		outputs[i] = g.binary(goos, goarch)
+		b.Println("building for", platform)
+		if *parallel {
+			wg.Add(1)
+			go func(goos, goarch, output string) {
+				defer wg.Done()
+				g.build(goos, goarch, output)
+			}(goos, goarch, outputs[i])
+		} else {
+			g.build(goos, goarch, outputs[i])
+		}
+	}
+	wg.Wait()
+	return outputs
+}
+
+func (g GoBuild) build(goos, goarch, output string) {
+	b.Go().WithEnv(g.env(goos, goarch)...).Run(g.args(output)...)
+}
+
+func (g GoBuild) targets() []string {
+	current := runtime.GOOS + "/" + runtime.GOARCH
+	if !*cross || len(g.platforms) == 0 {
+		return []string{current}
+	}
+	return g.platforms
+}
+
+// binary returns the output path, suffixed with the platform if platforms
+// have been set.
+func (g GoBuild) binary(goos, goarch string) string {
+	name := g.name
+	if len(g.platforms) > 0 {
+		name += "-" + goos + "-" + goarch
+	}
+	return filepath.ToSlash(filepath.Join(g.output, name+b.Exe(goos)))
+}
+
+func (g GoBuild) env(goos, goarch string) []string {
+	env := []string{"GOOS=" + goos, "GOARCH=" + goarch}
+	if g.cgo != nil {
+		cgo := "0"
+		if *g.cgo {
+			cgo = "1"
+		}
+		env = append(env, "CGO_ENABLED="+cgo)
+	}
+	return env
+}
+
+func (g GoBuild) args(output string) []string {
+	args := []string{"build", "-o", output}
+	if g.gopath != "" {
+		args = append(args, "-gcflags=all=-trimpath="+g.gopath, "-asmflags=all=-trimpath="+g.gopath)
+	} else if g.trimpath {
+		args = append(args, "-trimpath")
+	}
+	if g.versionVar != "" {
+		args = append(args, "-ldflags", "-X "+g.versionVar+"="+g.version)
+	}
+	args = append(args, g.flags...)
+	return append(args, g.pkg)
+}
+
+func splitPlatform(platform string) (string, string) {
+	parts := strings.SplitN(platform, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		b.Fatalln("invalid platform", platform, "must be os/arch")
+	}
+	return parts[0], parts[1]
+}
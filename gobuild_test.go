@@ -0,0 +1,51 @@
+package building
+
+import (
+	"runtime"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestGoBuildArgs(t *testing.T) {
+	b := &B{root: "github.com/mat007/brique"}
+	g := b.GoBuild("./cmd/app")
+	assert.DeepEqual(t, g.args("app"), []string{"build", "-o", "app", "./cmd/app"})
+	g = g.WithTrimpath().WithVersionVar("main.version").WithVersion("v1.0.0-3-g1234567").WithFlags("-v")
+	assert.DeepEqual(t, g.args("app"), []string{"build", "-o", "app", "-trimpath",
+		"-ldflags", "-X main.version=v1.0.0-3-g1234567", "-v", "./cmd/app"})
+	g.gopath = "/go"
+	assert.DeepEqual(t, g.args("app"), []string{"build", "-o", "app", "-gcflags=all=-trimpath=/go", "-asmflags=all=-trimpath=/go",
+		"-ldflags", "-X main.version=v1.0.0-3-g1234567", "-v", "./cmd/app"})
+}
+
+func TestGoBuildEnv(t *testing.T) {
+	b := &B{root: "github.com/mat007/brique"}
+	g := b.GoBuild("./cmd/app")
+	assert.DeepEqual(t, g.env("linux", "arm64"), []string{"GOOS=linux", "GOARCH=arm64"})
+	assert.DeepEqual(t, g.WithCGO(false).env("linux", "arm64"), []string{"GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0"})
+	assert.DeepEqual(t, g.WithCGO(true).env("darwin", "amd64"), []string{"GOOS=darwin", "GOARCH=amd64", "CGO_ENABLED=1"})
+}
+
+func TestGoBuildBinary(t *testing.T) {
+	b := &B{root: "github.com/mat007/brique"}
+	g := b.GoBuild("github.com/mat007/brique/cmd/app")
+	assert.Equal(t, g.binary("windows", "amd64"), "app.exe")
+	g = g.WithOutput("dist").WithPlatforms("linux/amd64", "windows/amd64")
+	assert.Equal(t, g.binary("linux", "amd64"), "dist/app-linux-amd64")
+	assert.Equal(t, g.binary("windows", "amd64"), "dist/app-windows-amd64.exe")
+	assert.Equal(t, g.WithName("tool").binary("linux", "arm64"), "dist/tool-linux-arm64")
+	assert.Equal(t, b.GoBuild(".").binary("linux", "amd64"), "brique")
+}
+
+func TestGoBuildTargets(t *testing.T) {
+	defer func(c bool) { *cross = c }(*cross)
+	b := &B{root: "github.com/mat007/brique"}
+	current := runtime.GOOS + "/" + runtime.GOARCH
+	g := b.GoBuild("./cmd/app").WithPlatforms("linux/amd64", "linux/arm64")
+	*cross = false
+	assert.DeepEqual(t, g.targets(), []string{current})
+	*cross = true
+	assert.DeepEqual(t, g.targets(), []string{"linux/amd64", "linux/arm64"})
+	assert.DeepEqual(t, b.GoBuild("./cmd/app").targets(), []string{current})
+}
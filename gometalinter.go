@@ -3,7 +3,7 @@ package building
 var GoMetaLinterVersion = "2.0.5"
 
 func (b *B) GoMetaLinter(args ...string) Tool {
-	return b.MakeTool(
+	t := b.MakeTool(
 		"gometalinter",
 		"--version",
 		"https://github.com/alecthomas/gometalinter",
@@ -13,6 +13,10 @@ RUN apk add --no-cache curl && \
     curl -L https://github.com/alecthomas/gometalinter/archive/v`+GoMetaLinterVersion+`.tar.gz | tar xz --strip-components=1 && \
 	go build -v -o /usr/local/bin/gometalinter . && \
 	gometalinter --install && \
-	rm -rf /go/src/* /go/pkg/*`,
-		args...)
+	rm -rf /go/src/* /go/pkg/*`).
+		WithBinaries("/usr/local/bin/gometalinter", "/go/bin")
+	if len(args) > 0 {
+		t.Run(args...)
+	}
+	return t
 }
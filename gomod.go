@@ -0,0 +1,172 @@
+package building
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GoMod wraps the go mod commands of a module.
+type GoMod struct {
+	dir string
+}
+
+// GoMod handles the Go module of the project.
+func (b *B) GoMod() GoMod {
+	return GoMod{dir: "."}
+}
+
+// WithDir sets the folder of the module, relative to the project root.
+func (m GoMod) WithDir(dir string) GoMod {
+	m.dir = dir
+	return m
+}
+
+// Tidy adds missing and removes unused requirements.
+func (m GoMod) Tidy() {
+	m.run("tidy")
+}
+
+// Download downloads the requirements into the module cache.
+func (m GoMod) Download() {
+	m.run("download")
+}
+
+// Verify checks the requirements in the module cache have not been modified.
+func (m GoMod) Verify() {
+	m.run("verify")
+}
+
+// CheckTidy fails if go mod tidy would modify go.mod or go.sum, leaving them
+// untouched.
+func (m GoMod) CheckTidy() {
+	files := []string{filepath.Join(m.dir, "go.mod"), filepath.Join(m.dir, "go.sum")}
+	before := readFiles(files)
+	defer restoreFiles(before)
+	m.run("tidy")
+	if changed := changedFiles(before, readFiles(files)); len(changed) > 0 {
+		b.Fatalln(strings.Join(changed, ", "), "not tidy, run go mod tidy")
+	}
+}
+
+func (m GoMod) run(args ...string) {
+	b.Go().WithDir(m.dir).Run(append([]string{"mod"}, args...)...)
+}
+
+// readFiles returns the content of files, nil if missing.
+func readFiles(files []string) map[string][]byte {
+	contents := map[string][]byte{}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			b.Fatalln(err)
+		}
+		contents[f] = content
+	}
+	return contents
+}
+
+func restoreFiles(contents map[string][]byte) {
+	for f, content := range contents {
+		if content == nil {
+			b.Check(os.RemoveAll(f))
+		} else {
+			b.Check(ioutil.WriteFile(f, content, 0644))
+		}
+	}
+}
+
+func changedFiles(before, after map[string][]byte) []string {
+	var changed []string
+	for _, f := range sortedFiles(before) {
+		if !bytes.Equal(before[f], after[f]) {
+			changed = append(changed, filepath.ToSlash(f))
+		}
+	}
+	return changed
+}
+
+func sortedFiles(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findModule returns the module path declared in the go.mod of dir or of
+// its closest parent along with the folder holding it, or empty strings if
+// there is none.
+// The search stops at the root of a git repository or at GOPATH/src, so that
+// a go.mod higher up does not turn a GOPATH project into a module.
+func findModule(dir string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			defer b.Close(f)
+			path, err := modulePath(f)
+			return path, dir, err
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || moduleBoundary(dir) {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// moduleBoundary tells whether dir is the root of a git repository or a
+// GOPATH/src folder.
+func moduleBoundary(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		gopath = filepath.Join(os.Getenv("HOME"), "go")
+	}
+	for _, p := range filepath.SplitList(gopath) {
+		if filepath.Join(p, "src") == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// modulePath returns the path from the module directive of a go.mod.
+func modulePath(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if strings.HasPrefix(fields[1], `"`) || strings.HasPrefix(fields[1], "`") {
+			return strconv.Unquote(fields[1])
+		}
+		return fields[1], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("missing module directive in go.mod")
+}
@@ -0,0 +1,89 @@
+package building
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestModulePath(t *testing.T) {
+	checkModulePath(t, "module example.com/a\n\ngo 1.11\n", "example.com/a")
+	checkModulePath(t, "// comment\nmodule example.com/a // comment\n", "example.com/a")
+	checkModulePath(t, "module \"example.com/a\"\n", "example.com/a")
+}
+
+func checkModulePath(t *testing.T, content, expected string) {
+	t.Helper()
+	path, err := modulePath(strings.NewReader(content))
+	assert.NilError(t, err)
+	assert.Equal(t, path, expected)
+}
+
+func TestModulePathMissing(t *testing.T) {
+	_, err := modulePath(strings.NewReader("go 1.11\n"))
+	assert.Error(t, err, "missing module directive in go.mod")
+}
+
+func TestFindModule(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile("go.mod", "module example.com/a\n"),
+		fs.WithDir("cmd", fs.WithDir("build")))
+	defer rootDirectory.Remove()
+
+	path, dir, err := findModule(rootDirectory.Path())
+	assert.NilError(t, err)
+	assert.Equal(t, path, "example.com/a")
+	assert.Equal(t, dir, rootDirectory.Path())
+	path, dir, err = findModule(filepath.Join(rootDirectory.Path(), "cmd", "build"))
+	assert.NilError(t, err)
+	assert.Equal(t, path, "example.com/a")
+	assert.Equal(t, dir, rootDirectory.Path())
+}
+
+func TestFindNoModule(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	path, dir, err := findModule(rootDirectory.Path())
+	assert.NilError(t, err)
+	assert.Equal(t, path, "")
+	assert.Equal(t, dir, "")
+}
+
+func TestFindModuleStopsAtBoundaries(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile("go.mod", "module example.com/stray\n"),
+		fs.WithDir("gopath", fs.WithDir("src", fs.WithDir("example.com", fs.WithDir("a")))),
+		fs.WithDir("repo", fs.WithDir(".git"), fs.WithDir("cmd")))
+	defer rootDirectory.Remove()
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	os.Setenv("GOPATH", filepath.Join(rootDirectory.Path(), "gopath"))
+
+	path, _, err := findModule(filepath.Join(rootDirectory.Path(), "gopath", "src", "example.com", "a"))
+	assert.NilError(t, err)
+	assert.Equal(t, path, "")
+	path, _, err = findModule(filepath.Join(rootDirectory.Path(), "repo", "cmd"))
+	assert.NilError(t, err)
+	assert.Equal(t, path, "")
+}
+
+func TestChangedFiles(t *testing.T) {
+	before := map[string][]byte{"go.mod": []byte("module a\n"), "go.sum": nil}
+	assert.Equal(t, len(changedFiles(before, before)), 0)
+	assert.DeepEqual(t, changedFiles(before, map[string][]byte{"go.mod": []byte("module a\n\ngo 1.11\n"), "go.sum": []byte("")}),
+		[]string{"go.mod"})
+	assert.DeepEqual(t, changedFiles(before, map[string][]byte{"go.mod": []byte("module a\n"), "go.sum": []byte("a v1.0.0 h1:\n")}),
+		[]string{"go.sum"})
+}
+
+func TestContainerWorkdir(t *testing.T) {
+	assert.Equal(t, Tool{root: "example.com/a", dir: "sub"}.workdir("/home/a"), "/go/src/example.com/a/sub")
+	assert.Equal(t, Tool{root: "example.com/a", module: true, moduleDir: "/home/a"}.workdir("/home/a"), "/src")
+	assert.Equal(t, Tool{root: "example.com/a", module: true, moduleDir: "/home/a", dir: "sub"}.workdir("/home/a/cmd"), "/src/cmd/sub")
+	assert.Equal(t, Tool{root: "example.com/a", module: true, moduleDir: "/home/a"}.mount(), "/src")
+	assert.Equal(t, Tool{root: "example.com/a"}.mount(), "/go/src/example.com/a")
+}
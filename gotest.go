@@ -0,0 +1,390 @@
+package building
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GoTest wraps go test with a summary of the results and JUnit reports.
+type GoTest struct {
+	pkgs   []string
+	run    string
+	race   bool
+	random bool
+	rerun  bool
+	junit  string
+	flags  []string
+	output io.Writer
+}
+
+// GoTest handles testing Go packages, defaulting to "./...".
+func (b *B) GoTest(pkgs ...string) GoTest {
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return GoTest{
+		pkgs: pkgs,
+	}
+}
+
+// WithRun only runs the tests matching pattern, like -test.run.
+func (g GoTest) WithRun(pattern string) GoTest {
+	g.run = pattern
+	return g
+}
+
+// WithRace enables the data race detector.
+func (g GoTest) WithRace() GoTest {
+	g.race = true
+	return g
+}
+
+// WithShuffle randomizes the execution order of tests, ignored with go
+// versions older than 1.17.
+func (g GoTest) WithShuffle() GoTest {
+	g.random = true
+	return g
+}
+
+// WithRerunFailed runs the failed tests once more, the build failing only if
+// they fail again.
+func (g GoTest) WithRerunFailed() GoTest {
+	g.rerun = true
+	return g
+}
+
+// WithJUnit writes a JUnit XML report of the results to path.
+func (g GoTest) WithJUnit(path string) GoTest {
+	g.junit = path
+	return g
+}
+
+// WithFlags adds flags to go test.
+func (g GoTest) WithFlags(flags ...string) GoTest {
+	g.flags = append(g.flags, flags...)
+	return g
+}
+
+// WithOutput sets where the summary gets written, defaults to stdout.
+func (g GoTest) WithOutput(w io.Writer) GoTest {
+	g.output = w
+	return g
+}
+
+// Run runs the tests and fails the build if any of them fails.
+func (g GoTest) Run() {
+	if g.output == nil {
+		g.output = os.Stdout
+	}
+	if g.random && !b.goSupports("1.17") {
+		b.Println("not shuffling tests, which requires go 1.17")
+		g.random = false
+	}
+	report := newTestReport(g.output)
+	g.test(report, g.run, g.pkgs)
+	if failed := report.failed(); len(failed) > 0 && g.rerun {
+		for _, pkg := range report.packages {
+			if tests := failed[pkg.Name]; len(tests) > 0 {
+				b.Println("re-running", len(tests), "failed tests of", pkg.Name)
+				g.test(report, "^("+strings.Join(tests, "|")+")$", []string{pkg.Name})
+			}
+		}
+	}
+	if g.junit != "" {
+		if err := report.writeJUnit(g.junit); err != nil {
+			b.Fatalln(err)
+		}
+	}
+	if failed := report.failed(); len(failed) > 0 {
+		var pkgs []string
+		for pkg := range failed {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		b.Fatalln("tests failed in", strings.Join(pkgs, ", "))
+	}
+}
+
+func (g GoTest) test(report *testReport, run string, pkgs []string) {
+	w := &lineWriter{line: report.parse}
+	code := b.Go().WithOutput(w).WithSuccess().Run(g.args(run, pkgs)...)
+	w.Flush()
+	if code != 0 && len(report.failed()) == 0 {
+		b.Fatalln("go test failed with exit code", code)
+	}
+}
+
+func (g GoTest) args(run string, pkgs []string) []string {
+	args := []string{"test", "-json"}
+	if run != "" {
+		args = append(args, "-run", run)
+	}
+	if g.race {
+		args = append(args, "-race")
+	}
+	if g.random {
+		args = append(args, "-shuffle=on")
+	}
+	args = append(args, g.flags...)
+	return append(args, pkgs...)
+}
+
+// testEvent is an event emitted by go test -json, see go doc test2json.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type testResult struct {
+	Name    string
+	Action  string
+	Elapsed float64
+	Output  []string
+}
+
+type testReport struct {
+	packages []*testResult
+	tests    map[string][]*testResult
+	output   io.Writer
+}
+
+func newTestReport(w io.Writer) *testReport {
+	return &testReport{
+		tests:  make(map[string][]*testResult),
+		output: w,
+	}
+}
+
+func (r *testReport) parse(line string) {
+	var e testEvent
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &e) != nil {
+		fmt.Fprintln(r.output, line)
+		return
+	}
+	r.add(e)
+}
+
+func (r *testReport) add(e testEvent) {
+	if e.Package == "" {
+		// build output is reported before the test binary runs
+		fmt.Fprint(r.output, e.Output)
+		return
+	}
+	pkg := r.pkg(e.Package)
+	result := pkg
+	if e.Test != "" {
+		result = r.test(pkg.Name, e.Test, e.Action == "run")
+	}
+	switch e.Action {
+	case "output":
+		result.Output = append(result.Output, e.Output)
+		if isDebug() {
+			fmt.Fprint(r.output, e.Output)
+		}
+	case "pass", "fail", "skip", "build-fail":
+		if e.Action == "build-fail" {
+			e.Action = "fail"
+		}
+		result.Action = e.Action
+		result.Elapsed = e.Elapsed
+		if e.Test == "" {
+			r.summary(pkg)
+		}
+	}
+}
+
+func (r *testReport) pkg(name string) *testResult {
+	for _, pkg := range r.packages {
+		if pkg.Name == name {
+			return pkg
+		}
+	}
+	pkg := &testResult{Name: name}
+	r.packages = append(r.packages, pkg)
+	return pkg
+}
+
+// test returns the result of a test, reset if run again.
+func (r *testReport) test(pkg, name string, reset bool) *testResult {
+	for _, t := range r.tests[pkg] {
+		if t.Name == name {
+			if reset {
+				*t = testResult{Name: name}
+			}
+			return t
+		}
+	}
+	t := &testResult{Name: name}
+	r.tests[pkg] = append(r.tests[pkg], t)
+	return t
+}
+
+func (r *testReport) summary(pkg *testResult) {
+	counts := map[string]int{}
+	for _, t := range r.tests[pkg.Name] {
+		counts[t.Action]++
+	}
+	status := "ok  "
+	if pkg.Action == "fail" {
+		status = "FAIL"
+	}
+	line := fmt.Sprintf("%s %s (%.2fs)", status, pkg.Name, pkg.Elapsed)
+	if len(r.tests[pkg.Name]) == 0 {
+		line += " no tests"
+	}
+	for _, c := range []struct{ action, desc string }{{"pass", "passed"}, {"fail", "failed"}, {"skip", "skipped"}} {
+		if counts[c.action] > 0 {
+			line += fmt.Sprintf(" %d %s", counts[c.action], c.desc)
+		}
+	}
+	fmt.Fprintln(r.output, line)
+	if pkg.Action != "fail" || isDebug() {
+		return
+	}
+	failed := false
+	for _, t := range r.tests[pkg.Name] {
+		if t.Action == "fail" {
+			failed = true
+			fmt.Fprint(r.output, indent(t.Output))
+		}
+	}
+	if !failed {
+		fmt.Fprint(r.output, indent(pkg.Output))
+	}
+}
+
+func indent(lines []string) string {
+	s := ""
+	for _, l := range lines {
+		s += "    " + l
+	}
+	return s
+}
+
+// failed returns the top level failed tests by package, or the package
+// alone if it failed with no test failing, e.g. because of a build error.
+func (r *testReport) failed() map[string][]string {
+	failed := make(map[string][]string)
+	for _, pkg := range r.packages {
+		if pkg.Action != "fail" {
+			continue
+		}
+		tests := []string{}
+		for _, t := range r.tests[pkg.Name] {
+			if t.Action == "fail" && !strings.Contains(t.Name, "/") {
+				tests = append(tests, regexp.QuoteMeta(t.Name))
+			}
+		}
+		failed[pkg.Name] = tests
+	}
+	return failed
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+func (r *testReport) junit() junitSuites {
+	var suites junitSuites
+	for _, pkg := range r.packages {
+		suite := junitSuite{
+			Name: pkg.Name,
+			Time: fmt.Sprintf("%.3f", pkg.Elapsed),
+		}
+		tests := r.tests[pkg.Name]
+		if pkg.Action == "fail" && len(r.failed()[pkg.Name]) == 0 {
+			tests = append(tests, &testResult{Name: "TestMain", Action: "fail", Output: pkg.Output})
+		}
+		for _, t := range tests {
+			c := junitCase{
+				ClassName: pkg.Name,
+				Name:      t.Name,
+				Time:      fmt.Sprintf("%.3f", t.Elapsed),
+			}
+			switch t.Action {
+			case "fail":
+				c.Failure = &junitMessage{Message: "Failed", Content: strings.Join(t.Output, "")}
+				suite.Failures++
+			case "skip":
+				c.Skipped = &junitMessage{Message: "Skipped", Content: strings.Join(t.Output, "")}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+		suites.Suites = append(suites.Suites, suite)
+	}
+	return suites
+}
+
+func (r *testReport) writeJUnit(path string) error {
+	content, err := xml.MarshalIndent(r.junit(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(content, '\n')...), 0644)
+}
+
+// lineWriter calls line for each line written, without the line ending.
+type lineWriter struct {
+	buf  bytes.Buffer
+	line func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i == -1 {
+			return len(p), nil
+		}
+		line := string(w.buf.Next(i + 1))
+		w.line(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// Flush calls line with what remains.
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.line(strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,108 @@
+package building
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+const testEvents = `{"Action":"run","Package":"a","Test":"TestOK"}
+{"Action":"output","Package":"a","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"a","Test":"TestOK","Elapsed":0.01}
+{"Action":"run","Package":"a","Test":"TestKO"}
+{"Action":"run","Package":"a","Test":"TestKO/sub"}
+{"Action":"output","Package":"a","Test":"TestKO/sub","Output":"    a_test.go:12: boom\n"}
+{"Action":"fail","Package":"a","Test":"TestKO/sub","Elapsed":0}
+{"Action":"fail","Package":"a","Test":"TestKO","Elapsed":0.02}
+{"Action":"run","Package":"a","Test":"TestSkip"}
+{"Action":"skip","Package":"a","Test":"TestSkip","Elapsed":0}
+{"Action":"fail","Package":"a","Elapsed":0.5}
+{"Action":"output","Package":"b","Output":"?   \tb\t[no test files]\n"}
+{"Action":"skip","Package":"b","Elapsed":0}
+`
+
+func parseEvents(r *testReport, events string) {
+	w := &lineWriter{line: r.parse}
+	w.Write([]byte(events))
+	w.Flush()
+}
+
+func TestGoTestSummary(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := newTestReport(buf)
+	parseEvents(r, testEvents)
+	assert.Equal(t, buf.String(), `FAIL a (0.50s) 1 passed 2 failed 1 skipped
+        a_test.go:12: boom
+ok   b (0.00s) no tests
+`)
+	assert.DeepEqual(t, r.failed(), map[string][]string{"a": {"TestKO"}})
+}
+
+func TestGoTestRerun(t *testing.T) {
+	r := newTestReport(&bytes.Buffer{})
+	parseEvents(r, testEvents)
+	parseEvents(r, `{"Action":"run","Package":"a","Test":"TestKO"}
+{"Action":"run","Package":"a","Test":"TestKO/sub"}
+{"Action":"pass","Package":"a","Test":"TestKO/sub","Elapsed":0}
+{"Action":"pass","Package":"a","Test":"TestKO","Elapsed":0.02}
+{"Action":"pass","Package":"a","Elapsed":0.1}
+`)
+	assert.Equal(t, len(r.failed()), 0)
+}
+
+func TestGoTestBuildFailure(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := newTestReport(buf)
+	parseEvents(r, `{"ImportPath":"a [a.test]","Action":"build-output","Output":"a/a.go:3:1: syntax error\n"}
+{"ImportPath":"a [a.test]","Action":"build-fail"}
+FAIL	a [build failed]
+{"Action":"output","Package":"a","Output":"FAIL\ta [build failed]\n"}
+{"Action":"fail","Package":"a","Elapsed":0}
+`)
+	assert.Equal(t, buf.String(), `a/a.go:3:1: syntax error
+FAIL	a [build failed]
+FAIL a (0.00s) no tests
+    FAIL	a [build failed]
+`)
+	assert.DeepEqual(t, r.failed(), map[string][]string{"a": {}})
+}
+
+func TestGoTestJUnit(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	r := newTestReport(&bytes.Buffer{})
+	parseEvents(r, testEvents)
+	path := filepath.Join(rootDirectory.Path(), "reports", "junit.xml")
+	assert.NilError(t, r.writeJUnit(path))
+	content, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="a" tests="4" failures="2" skipped="1" time="0.500">
+    <testcase classname="a" name="TestOK" time="0.010"></testcase>
+    <testcase classname="a" name="TestKO" time="0.020">
+      <failure message="Failed"></failure>
+    </testcase>
+    <testcase classname="a" name="TestKO/sub" time="0.000">
+      <failure message="Failed">    a_test.go:12: boom&#xA;</failure>
+    </testcase>
+    <testcase classname="a" name="TestSkip" time="0.000">
+      <skipped message="Skipped"></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="b" tests="0" failures="0" skipped="0" time="0.000"></testsuite>
+</testsuites>
+`)
+}
+
+func TestGoTestArgs(t *testing.T) {
+	g := b.GoTest().WithRace().WithShuffle().WithFlags("-count=1")
+	assert.Equal(t, strings.Join(g.args("^TestA$", g.pkgs), " "),
+		"test -json -run ^TestA$ -race -shuffle=on -count=1 ./...")
+}
@@ -0,0 +1,92 @@
+package building
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	imagesArchive = flag.String("images", "images.tar", "archive for the save-images and load-images targets")
+)
+
+// SaveImages exports the tool images built for the project along with their
+// base images into an archive.
+func (b *B) SaveImages() {
+	r := containerEngine()
+	out, err := exec.Command(r.name, r.imagesArgs(imagePrefix(b.root))...).Output()
+	if err != nil {
+		b.Fatalln("failed to list images:", err)
+	}
+	images := strings.Fields(string(out))
+	if len(images) == 0 {
+		b.Fatalln("no tool images found, run a build first")
+	}
+	// the base images are needed as well to check the tool images are up
+	// to date
+	for _, image := range images {
+		for _, base := range strings.Fields(r.imageLabel(image, basesLabel)) {
+			if !contains(images, base) {
+				images = append(images, base)
+			}
+		}
+	}
+	b.Println("saving", images, "to", *imagesArchive)
+	b.runEngine(r, r.saveArgs(*imagesArchive, images), nil)
+}
+
+// LoadImages imports tool images from an archive.
+func (b *B) LoadImages() {
+	r := containerEngine()
+	b.Println("loading images from", *imagesArchive)
+	b.runEngine(r, r.loadArgs(*imagesArchive), nil)
+}
+
+func (b *B) runEngine(r containerRuntime, args []string, input io.Reader) {
+	b.Debugln("running", append([]string{r.name}, args...))
+	cmd := exec.Command(r.name, args...)
+	cmd.Stdin = input
+	cmd.Stderr = os.Stderr
+	if isDebug() {
+		cmd.Stdout = os.Stdout
+	}
+	if err := cmd.Run(); err != nil {
+		b.Fatalln(err)
+	}
+}
+
+// buildEngine builds an image through the Docker Engine API from a context
+// tar archive, only showing the build output if it fails unless debugging.
+func (b *B) buildEngine(api *dockerAPI, c containerBuild, context io.Reader) {
+	output := &bytes.Buffer{}
+	var w io.Writer = output
+	if isDebug() {
+		w = os.Stdout
+	}
+	if err := api.build(c, context, w); err != nil {
+		os.Stderr.Write(output.Bytes())
+		b.Fatalln(err)
+	}
+}
+
+// pullImage pulls image from its registry, only showing the progress if it
+// fails unless debugging.
+func (b *B) pullImage(r containerRuntime, image string) {
+	api := r.engine()
+	if api == nil {
+		b.runEngine(r, r.pullArgs(image), nil)
+		return
+	}
+	output := &bytes.Buffer{}
+	var w io.Writer = output
+	if isDebug() {
+		w = os.Stdout
+	}
+	if err := api.pull(image, w); err != nil {
+		os.Stderr.Write(output.Bytes())
+		b.Fatalln(err)
+	}
+}
@@ -1,12 +1,16 @@
 package building
 
 func (b *B) Jq(args ...string) Tool {
-	return b.MakeTool(
+	t := b.MakeTool(
 		"jq",
 		"--help",
 		"https://stedolan.github.io/jq",
 		`
 FROM alpine:`+AlpineVersion+`
-RUN apk add --no-cache jq`,
-		args...)
+RUN apk add --no-cache jq`).
+		WithPackages("jq")
+	if len(args) > 0 {
+		t.Run(args...)
+	}
+	return t
 }
@@ -18,6 +18,7 @@ var (
 
 func init() {
 	log.SetFlags(0)
+	log.SetOutput(masker{w: os.Stderr})
 	// Manual flags parsing to disable logging before calling the target
 	// functions unless -v is passed.
 	*Quiet = true
@@ -35,6 +36,7 @@ type failure struct {
 
 func CatchFailure(start time.Time) {
 	if e := recover(); e != nil {
+		b.stopServices(0)
 		if _, ok := e.(failure); ok {
 			b.Debugf("build failed (took %s)", time.Since(start))
 			os.Exit(1)
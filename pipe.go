@@ -0,0 +1,111 @@
+package building
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Stage is a step of a pipeline, made with Tool.Args or Command.Args.
+type Stage struct {
+	name    string
+	success bool
+	stderr  io.Writer
+	command func() *exec.Cmd
+}
+
+// Pipe runs the stages concurrently, connecting the output of each stage to
+// the input of the next one, and returns their exit codes.
+// The input of the first stage and output of the last one can be set with
+// WithInput and WithOutput on the tool or command.
+func (b *B) Pipe(stages ...Stage) []int {
+	codes, err := pipe(stages)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	return codes
+}
+
+func pipe(stages []Stage) ([]int, error) {
+	cmds := make([]*exec.Cmd, len(stages))
+	captured := make([]*bytes.Buffer, len(stages))
+	for i, s := range stages {
+		cmds[i] = s.command()
+		captured[i] = redirect(cmds[i], s.stderr)
+	}
+	// the parent closes its ends of the pipes once the stages started, so
+	// that a stage gets notified when the next one exits
+	var ends []*os.File
+	defer func() {
+		for _, f := range ends {
+			f.Close()
+		}
+	}()
+	for i := 1; i < len(cmds); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		ends = append(ends, r, w)
+		cmds[i-1].Stdout = w
+		cmds[i].Stdin = r
+	}
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			for _, started := range cmds[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return nil, err
+		}
+	}
+	for _, f := range ends {
+		f.Close()
+	}
+	ends = nil
+	codes := make([]int, len(cmds))
+	failed := false
+	var msgs []string
+	for i, cmd := range cmds {
+		err := cmd.Wait()
+		if i < len(cmds)-1 && brokenPipe(err) {
+			err = nil
+		}
+		code, err := exitCode(err, stages[i].success)
+		codes[i] = code
+		if err != nil {
+			failed = true
+			if msg := strings.TrimSpace(captured[i].String()); msg != "" {
+				msgs = append(msgs, stages[i].name+": "+msg)
+			}
+		}
+	}
+	if failed {
+		var status []string
+		for i, code := range codes {
+			status = append(status, fmt.Sprintf("%s=%d", stages[i].name, code))
+		}
+		msg := "pipeline failed with exit codes " + strings.Join(status, " ")
+		for _, m := range msgs {
+			msg += "\n" + m
+		}
+		return codes, errors.New(msg)
+	}
+	return codes, nil
+}
+
+// brokenPipe tells whether a stage got killed for writing to the next one
+// after it exited, which ends a pipeline normally.
+func brokenPipe(err error) bool {
+	exit, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	status, ok := exit.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGPIPE
+}
@@ -0,0 +1,73 @@
+package building
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPipe(t *testing.T) {
+	buf := &bytes.Buffer{}
+	codes, err := pipe([]Stage{
+		helper().Args(helperArgs("foo", "bar")...),
+		helper().Args(helperArgs("-cat")...),
+		helper().WithOutput(buf).Args(helperArgs("-cat", "baz")...),
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, codes, []int{0, 0, 0})
+	assert.Equal(t, buf.String(), "foo\nbar\nbaz\n")
+}
+
+func TestPipeWithInput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	codes, err := pipe([]Stage{
+		helper().WithInput(strings.NewReader("foo\n")).Args(helperArgs("-cat")...),
+		helper().WithOutput(buf).Args(helperArgs("-cat")...),
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, codes, []int{0, 0})
+	assert.Equal(t, buf.String(), "foo\n")
+}
+
+func TestPipeConsumerExitsEarly(t *testing.T) {
+	buf := &bytes.Buffer{}
+	codes, err := pipe([]Stage{
+		helper().Args(helperArgs("-yes")...),
+		helper().WithOutput(buf).Args(helperArgs("-head")...),
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, codes, []int{0, 0})
+	assert.Equal(t, buf.String(), "y\n")
+}
+
+func TestPipeFailure(t *testing.T) {
+	codes, err := pipe([]Stage{
+		helper().WithStderr(&bytes.Buffer{}).Args(helperArgs("-stderr", "oops", "-exit", "2")...),
+		helper().WithOutput(&bytes.Buffer{}).Args(helperArgs("-cat")...),
+	})
+	assert.DeepEqual(t, codes, []int{2, 0})
+	assert.Error(t, err, "pipeline failed with exit codes "+os.Args[0]+"=2 "+os.Args[0]+"=0\n"+os.Args[0]+": oops")
+}
+
+func TestPipeWithSuccess(t *testing.T) {
+	codes, err := pipe([]Stage{
+		helper().WithSuccess().Args(helperArgs("-exit", "1")...),
+		helper().WithOutput(&bytes.Buffer{}).Args(helperArgs("-cat")...),
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, codes, []int{1, 0})
+}
+
+func TestPipeFatal(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "pipeline failed"), buf.String())
+	}()
+	b.Pipe(helper().Args(helperArgs("-exit", "1")...))
+}
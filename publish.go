@@ -0,0 +1,355 @@
+package building
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Publish wraps uploading release artifacts to GitHub or any service
+// implementing its REST API.
+type Publish struct {
+	url        string
+	repository string
+	tag        string
+	title      string
+	notes      string
+	draft      bool
+	prerelease bool
+	token      string
+	files      []string
+}
+
+// Publish handles creating a release and uploading its artifacts.
+// Running it again updates the release and only uploads the artifacts
+// missing or changed.
+func (b *B) Publish() Publish {
+	return Publish{
+		url:   "https://api.github.com",
+		token: "GITHUB_TOKEN",
+	}
+}
+
+// WithURL sets the base url of the API, defaults to https://api.github.com.
+func (p Publish) WithURL(url string) Publish {
+	p.url = strings.TrimSuffix(url, "/")
+	return p
+}
+
+// WithRepository sets the repository as "owner/name", defaults to the one
+// of the origin remote.
+func (p Publish) WithRepository(repository string) Publish {
+	p.repository = repository
+	return p
+}
+
+// WithTag sets the tag of the release, defaults to the v* tag of HEAD.
+func (p Publish) WithTag(tag string) Publish {
+	p.tag = tag
+	return p
+}
+
+// WithTitle sets the title of the release, defaults to the tag.
+func (p Publish) WithTitle(title string) Publish {
+	p.title = title
+	return p
+}
+
+// WithNotes sets the Markdown description of the release, see Changelog.
+func (p Publish) WithNotes(notes string) Publish {
+	p.notes = notes
+	return p
+}
+
+// WithDraft creates the release as a draft.
+func (p Publish) WithDraft() Publish {
+	p.draft = true
+	return p
+}
+
+// WithPrerelease marks the release as a prerelease.
+func (p Publish) WithPrerelease() Publish {
+	p.prerelease = true
+	return p
+}
+
+// WithToken sets the name of the secret holding the API token, defaults to
+// GITHUB_TOKEN, see Secret.
+func (p Publish) WithToken(name string) Publish {
+	p.token = name
+	return p
+}
+
+// WithFiles adds artifacts to upload.
+func (p Publish) WithFiles(paths ...string) Publish {
+	p.files = append(p.files, paths...)
+	return p
+}
+
+// WithManifest adds the artifacts listed in the manifest of a release
+// written into dir, see Release.
+func (p Publish) WithManifest(dir string) Publish {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	for _, a := range m.Artifacts {
+		p.files = append(p.files, a.Path)
+	}
+	return p
+}
+
+// Run creates or updates the release and uploads the artifacts.
+func (p Publish) Run() {
+	if p.repository == "" {
+		r := b.gitRepo()
+		if r == nil {
+			b.Fatalln("publish: missing repository")
+		}
+		remote, err := r.remote("origin")
+		if err != nil {
+			b.Fatalln("publish:", err)
+		}
+		if p.repository, err = repositoryName(remote); err != nil {
+			b.Fatalln("publish:", err)
+		}
+	}
+	if p.tag == "" {
+		if p.tag = latestVersion(lines(b.GitVersion())); p.tag == "" {
+			b.Fatalln("publish: HEAD has no v* semantic version tag")
+		}
+	}
+	if err := p.publish(&http.Client{}, b.Secret(p.token)); err != nil {
+		b.Fatalln("publish:", err)
+	}
+}
+
+// latestVersion returns the highest semantic version among tags, or an
+// empty string if none.
+func latestVersion(tags []string) string {
+	latest, tag := Version{}, ""
+	for _, t := range tags {
+		v, err := ParseVersion(t)
+		if err != nil {
+			continue
+		}
+		if tag == "" || latest.Less(v) {
+			latest, tag = v, t
+		}
+	}
+	return tag
+}
+
+var repositoryURL = regexp.MustCompile(`[:/]([^/:]+)/([^/]+?)(\.git)?/?$`)
+
+// repositoryName extracts "owner/name" from the url of a remote.
+func repositoryName(remote string) (string, error) {
+	m := repositoryURL.FindStringSubmatch(remote)
+	if m == nil {
+		return "", fmt.Errorf("cannot find repository in %s", remote)
+	}
+	return m[1] + "/" + m[2], nil
+}
+
+type githubRelease struct {
+	ID         int64         `json:"id,omitempty"`
+	TagName    string        `json:"tag_name"`
+	Name       string        `json:"name"`
+	Body       string        `json:"body"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	UploadURL  string        `json:"upload_url,omitempty"`
+	Assets     []githubAsset `json:"assets,omitempty"`
+}
+
+type githubAsset struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Digest is "sha256:<hex>", only provided by recent versions of the API.
+	Digest string `json:"digest,omitempty"`
+}
+
+// find returns the release of the tag, or nil if there is none.
+// Draft releases are not found by tag, so they get looked up among the most
+// recent releases.
+func (p Publish) find(api githubAPI, base string) (*githubRelease, error) {
+	var release githubRelease
+	err := api.do("GET", base+"/tags/"+url.PathEscape(p.tag), nil, "", &release)
+	if err == nil {
+		return &release, nil
+	}
+	if e, ok := err.(*githubError); !ok || e.status != http.StatusNotFound {
+		return nil, err
+	}
+	var releases []githubRelease
+	if err := api.do("GET", base+"?per_page=100", nil, "", &releases); err != nil {
+		return nil, err
+	}
+	for _, r := range releases {
+		if r.TagName == p.tag {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p Publish) publish(client *http.Client, token string) error {
+	api := githubAPI{client: client, token: token}
+	base := p.url + "/repos/" + p.repository + "/releases"
+	verb, method, endpoint := "creating", "POST", base
+	existing, err := p.find(api, base)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		verb, method, endpoint = "updating", "PATCH", fmt.Sprintf("%s/%d", base, existing.ID)
+	}
+	title := p.title
+	if title == "" {
+		title = p.tag
+	}
+	release := githubRelease{
+		TagName:    p.tag,
+		Name:       title,
+		Body:       p.notes,
+		Draft:      p.draft,
+		Prerelease: p.prerelease,
+	}
+	b.Println(verb, "release", p.tag, "of", p.repository)
+	body, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if err := api.do(method, endpoint, bytes.NewReader(body), "application/json", &release); err != nil {
+		return err
+	}
+	for _, file := range p.files {
+		if err := p.upload(api, base, release, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p Publish) upload(api githubAPI, base string, release githubRelease, file string) error {
+	artifact, err := makeArtifact(file)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(file)
+	for _, a := range release.Assets {
+		if a.Name != name {
+			continue
+		}
+		if a.Size == artifact.Size {
+			digest := a.Digest
+			if digest == "" {
+				// older versions of the API do not provide the digest
+				h := sha256.New()
+				if err := api.do("GET", fmt.Sprintf("%s/assets/%d", base, a.ID), nil, "", h); err != nil {
+					return err
+				}
+				digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+			}
+			if digest == "sha256:"+artifact.SHA256 {
+				b.Println("skipping", name, "already uploaded")
+				return nil
+			}
+		}
+		if err := api.do("DELETE", fmt.Sprintf("%s/assets/%d", base, a.ID), nil, "", nil); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer b.Close(f)
+	// the upload url is a template such as
+	// https://uploads.github.com/repos/o/r/releases/1/assets{?name,label}
+	upload := release.UploadURL
+	if i := strings.Index(upload, "{"); i != -1 {
+		upload = upload[:i]
+	}
+	b.Println("uploading", name)
+	return api.do("POST", upload+"?name="+url.QueryEscape(name), f, "application/octet-stream", nil)
+}
+
+type githubAPI struct {
+	client *http.Client
+	token  string
+}
+
+type githubError struct {
+	status  int
+	message string
+}
+
+func (e *githubError) Error() string {
+	return e.message
+}
+
+// do sends a request and decodes the JSON response into result if not nil,
+// or copies it if result is an io.Writer.
+func (a githubAPI) do(method, endpoint string, body io.Reader, contentType string, result interface{}) error {
+	size := int64(-1)
+	if f, ok := body.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		// the caller closes the file, not the client
+		size, body = info.Size(), ioutil.NopCloser(f)
+	}
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	w, download := result.(io.Writer)
+	if download {
+		req.Header.Set("Accept", "application/octet-stream")
+	} else {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "token "+a.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.Debugln(method, endpoint)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer b.Close(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &githubError{
+			status:  resp.StatusCode,
+			message: fmt.Sprintf("%s %s: %s %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	if download {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
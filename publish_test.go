@@ -0,0 +1,214 @@
+package building
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+// fakeGitHub implements the part of the GitHub releases API used by Publish.
+type fakeGitHub struct {
+	mutex    sync.Mutex
+	server   *httptest.Server
+	releases []*githubRelease
+	contents map[int64][]byte
+	requests []string
+	nextID   int64
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	g := &fakeGitHub{contents: map[int64][]byte{}}
+	g.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		g.requests = append(g.requests, r.Method+" "+r.URL.Path)
+		g.nextID++
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/repos/o/r/releases"), "/")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/o/r/releases":
+			assert.NilError(t, json.NewEncoder(w).Encode(g.releases))
+		case r.Method == "GET" && len(parts) == 3 && parts[1] == "tags":
+			// like GitHub, drafts are not found by tag
+			for _, release := range g.releases {
+				if release.TagName == parts[2] && !release.Draft {
+					assert.NilError(t, json.NewEncoder(w).Encode(release))
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case r.Method == "GET" && len(parts) == 3 && parts[1] == "assets":
+			assert.Equal(t, r.Header.Get("Accept"), "application/octet-stream")
+			id, err := strconv.ParseInt(parts[2], 10, 64)
+			assert.NilError(t, err)
+			w.Write(g.contents[id])
+		case r.Method == "POST" && r.URL.Path == "/repos/o/r/releases":
+			release := &githubRelease{}
+			assert.NilError(t, json.NewDecoder(r.Body).Decode(release))
+			release.ID = g.nextID
+			release.UploadURL = fmt.Sprintf("%s/uploads/%d/assets{?name,label}", g.server.URL, release.ID)
+			g.releases = append(g.releases, release)
+			w.WriteHeader(http.StatusCreated)
+			assert.NilError(t, json.NewEncoder(w).Encode(release))
+		case r.Method == "PATCH" && len(parts) == 2:
+			release := g.release(parts[1])
+			assert.NilError(t, json.NewDecoder(r.Body).Decode(release))
+			assert.NilError(t, json.NewEncoder(w).Encode(release))
+		case r.Method == "DELETE" && len(parts) == 3 && parts[1] == "assets":
+			for _, release := range g.releases {
+				for i, a := range release.Assets {
+					if strconv.FormatInt(a.ID, 10) == parts[2] {
+						release.Assets = append(release.Assets[:i], release.Assets[i+1:]...)
+						w.WriteHeader(http.StatusNoContent)
+						return
+					}
+				}
+			}
+			http.NotFound(w, r)
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/uploads/"):
+			release := g.release(strings.Split(r.URL.Path, "/")[2])
+			content, err := ioutil.ReadAll(r.Body)
+			assert.NilError(t, err)
+			assert.Equal(t, r.Header.Get("Content-Type"), "application/octet-stream")
+			g.contents[g.nextID] = content
+			release.Assets = append(release.Assets, githubAsset{
+				ID:   g.nextID,
+				Name: r.URL.Query().Get("name"),
+				Size: int64(len(content)),
+			})
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return g
+}
+
+func (g *fakeGitHub) release(id string) *githubRelease {
+	for _, r := range g.releases {
+		if strconv.FormatInt(r.ID, 10) == id {
+			return r
+		}
+	}
+	return &githubRelease{}
+}
+
+func TestPublish(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile("a.tar.gz", "a"),
+		fs.WithFile("b.zip", "b"))
+	defer rootDirectory.Remove()
+	g := newFakeGitHub(t)
+	defer g.server.Close()
+
+	files := []string{filepath.Join(rootDirectory.Path(), "a.tar.gz"), filepath.Join(rootDirectory.Path(), "b.zip")}
+	p := b.Publish().WithURL(g.server.URL + "/").WithRepository("o/r").WithTag("v1.0.0").
+		WithDraft().WithFiles(files...)
+	assert.NilError(t, p.publish(http.DefaultClient, "secret"))
+	assert.DeepEqual(t, g.requests, []string{
+		"GET /repos/o/r/releases/tags/v1.0.0",
+		"GET /repos/o/r/releases",
+		"POST /repos/o/r/releases",
+		"POST /uploads/3/assets",
+		"POST /uploads/3/assets",
+	})
+	assert.Equal(t, len(g.releases), 1)
+	assert.Equal(t, g.releases[0].Name, "v1.0.0")
+	assert.Assert(t, g.releases[0].Draft)
+	assert.Equal(t, len(g.releases[0].Assets), 2)
+
+	// publishing the draft again only replaces what changed, even keeping
+	// the size
+	g.requests = nil
+	assert.NilError(t, ioutil.WriteFile(files[1], []byte("c"), 0644))
+	assert.NilError(t, p.WithNotes("notes").publish(http.DefaultClient, "secret"))
+	assert.DeepEqual(t, g.requests, []string{
+		"GET /repos/o/r/releases/tags/v1.0.0",
+		"GET /repos/o/r/releases",
+		"PATCH /repos/o/r/releases/3",
+		"GET /repos/o/r/releases/assets/4",
+		"GET /repos/o/r/releases/assets/5",
+		"DELETE /repos/o/r/releases/assets/5",
+		"POST /uploads/3/assets",
+	})
+	assert.Equal(t, len(g.releases), 1)
+	assert.Assert(t, g.releases[0].Draft)
+	assert.Equal(t, g.releases[0].Body, "notes")
+	assert.Equal(t, len(g.releases[0].Assets), 2)
+	assert.DeepEqual(t, g.contents[g.releases[0].Assets[1].ID], []byte("c"))
+
+	// publishing the draft updates it too
+	g.requests = nil
+	p.draft = false
+	assert.NilError(t, p.WithPrerelease().WithNotes("notes").publish(http.DefaultClient, "secret"))
+	assert.DeepEqual(t, g.requests, []string{
+		"GET /repos/o/r/releases/tags/v1.0.0",
+		"GET /repos/o/r/releases",
+		"PATCH /repos/o/r/releases/3",
+		"GET /repos/o/r/releases/assets/4",
+		"GET /repos/o/r/releases/assets/12",
+	})
+	assert.Equal(t, len(g.releases), 1)
+	assert.Assert(t, !g.releases[0].Draft)
+	assert.Assert(t, g.releases[0].Prerelease)
+	assert.Equal(t, g.releases[0].Body, "notes")
+	assert.Equal(t, len(g.releases[0].Assets), 2)
+	assert.DeepEqual(t, g.contents[g.releases[0].Assets[1].ID], []byte("c"))
+}
+
+func TestPublishUnauthorized(t *testing.T) {
+	g := newFakeGitHub(t)
+	defer g.server.Close()
+
+	err := b.Publish().WithURL(g.server.URL).WithRepository("o/r").WithTag("v1.0.0").publish(http.DefaultClient, "wrong")
+	assert.Error(t, err, "GET "+g.server.URL+"/repos/o/r/releases/tags/v1.0.0: 401 Unauthorized bad credentials")
+}
+
+func TestRepositoryName(t *testing.T) {
+	for _, remote := range []string{
+		"https://github.com/mat007/brique",
+		"https://github.com/mat007/brique.git",
+		"git@github.com:mat007/brique.git",
+		"ssh://git@github.com/mat007/brique.git",
+	} {
+		name, err := repositoryName(remote)
+		assert.NilError(t, err)
+		assert.Equal(t, name, "mat007/brique")
+	}
+	_, err := repositoryName("brique")
+	assert.Error(t, err, "cannot find repository in brique")
+}
+
+func TestGitRemote(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+	git(t, dir.Path(), "remote", "add", "origin", "git@github.com:mat007/brique.git")
+
+	r, err := openGit(dir.Path())
+	assert.NilError(t, err)
+	remote, err := r.remote("origin")
+	assert.NilError(t, err)
+	assert.Equal(t, remote, "git@github.com:mat007/brique.git")
+	_, err = r.remote("upstream")
+	assert.Error(t, err, "unknown remote upstream")
+}
+
+func TestLatestVersion(t *testing.T) {
+	assert.Equal(t, latestVersion([]string{"v1.10.0", "v1.9.0"}), "v1.10.0")
+	assert.Equal(t, latestVersion([]string{"v2.0.0-rc.1", "v2.0.0", "v2.0.0-rc.2"}), "v2.0.0")
+	assert.Equal(t, latestVersion([]string{"v1.0.0-rc.2", "v1.0.0-rc.10", "vendor"}), "v1.0.0-rc.10")
+	assert.Equal(t, latestVersion([]string{"vendor"}), "")
+}
@@ -0,0 +1,233 @@
+package building
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"text/template"
+)
+
+// DefaultReleaseTemplate names the release archives.
+const DefaultReleaseTemplate = "{{.Name}}-{{.Version}}-{{.OS}}-{{.Arch}}"
+
+// Release wraps building and packaging a Go program for several platforms.
+type Release struct {
+	build     GoBuild
+	name      string
+	version   string
+	output    string
+	template  string
+	platforms []string
+	files     []string
+}
+
+// Artifact is a file produced by a release.
+type Artifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	OS     string `json:"os,omitempty"`
+	Arch   string `json:"arch,omitempty"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest describes the artifacts of a release.
+type Manifest struct {
+	Name      string     `json:"name"`
+	Version   string     `json:"version"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Release handles packaging the Go program pkg into a zip archive on
+// Windows and a tar.gz elsewhere for each platform, along with their
+// checksums and a manifest.json.
+func (b *B) Release(pkg string) Release {
+	build := b.GoBuild(pkg)
+	return Release{
+		build:    build,
+		name:     build.name,
+		output:   "dist",
+		template: DefaultReleaseTemplate,
+	}
+}
+
+// WithName sets the name of the program, defaults to the package base name.
+func (r Release) WithName(name string) Release {
+	r.name = name
+	return r
+}
+
+// WithVersion sets the version, defaults to the one computed from git, see
+// Version.
+func (r Release) WithVersion(version string) Release {
+	r.version = version
+	return r
+}
+
+// WithVersionVar injects the version into a string variable, e.g.
+// "main.version".
+func (r Release) WithVersionVar(name string) Release {
+	r.build = r.build.WithVersionVar(name)
+	return r
+}
+
+// WithOutput sets the folder receiving the release, defaults to "dist".
+func (r Release) WithOutput(dir string) Release {
+	r.output = dir
+	return r
+}
+
+// WithTemplate sets the text/template naming the archives without
+// extension, see DefaultReleaseTemplate.
+func (r Release) WithTemplate(template string) Release {
+	r.template = template
+	return r
+}
+
+// WithPlatforms sets the platforms as "os/arch", defaults to the current
+// one.
+func (r Release) WithPlatforms(platforms ...string) Release {
+	r.platforms = append(r.platforms, platforms...)
+	return r
+}
+
+// WithFiles adds files to each archive, for instance LICENSE or README.md.
+func (r Release) WithFiles(paths ...string) Release {
+	r.files = append(r.files, paths...)
+	return r
+}
+
+// WithBuild customizes the build, for instance with GoBuild.WithTrimpath.
+func (r Release) WithBuild(f func(GoBuild) GoBuild) Release {
+	r.build = f(r.build)
+	return r
+}
+
+// Run packages the release and returns its manifest.
+func (r Release) Run() Manifest {
+	if r.version == "" {
+		r.version = b.Version().String()
+	}
+	platforms := r.platforms
+	if len(platforms) == 0 {
+		platforms = []string{runtime.GOOS + "/" + runtime.GOARCH}
+	}
+	build := r.build.WithVersion(r.version)
+	m := Manifest{
+		Name:    r.name,
+		Version: r.version,
+	}
+	for _, platform := range platforms {
+		goos, goarch := splitPlatform(platform)
+		name, err := r.archiveName(goos, goarch)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		b.Println("packaging", name)
+		staging := filepath.Join(r.output, name)
+		build.build(goos, goarch, filepath.ToSlash(filepath.Join(staging, r.name+b.Exe(goos))))
+		if len(r.files) > 0 {
+			b.Copy(staging+"/", r.files...)
+		}
+		archive := path.Join(filepath.ToSlash(r.output), name+archiveExtension(goos))
+		if goos == "windows" {
+			b.Zip().WithFileset(staging, "*", "").Run(archive)
+		} else {
+			b.Tar().WithFileset(staging, "*", "").Run(archive)
+		}
+		b.Remove(staging)
+		a, err := makeArtifact(archive)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		a.OS, a.Arch = goos, goarch
+		m.Artifacts = append(m.Artifacts, a)
+	}
+	if err := m.write(r.output); err != nil {
+		b.Fatalln(err)
+	}
+	return m
+}
+
+func (r Release) archiveName(goos, goarch string) (string, error) {
+	t, err := template.New("release").Option("missingkey=error").Parse(r.template)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = t.Execute(buf, map[string]string{
+		"Name":    r.name,
+		"Version": r.version,
+		"OS":      goos,
+		"Arch":    goarch,
+	})
+	return buf.String(), err
+}
+
+func archiveExtension(goos string) string {
+	if goos == "windows" {
+		return ".zip"
+	}
+	return ".tar.gz"
+}
+
+func makeArtifact(file string) (Artifact, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer b.Close(f)
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{
+		Name:   path.Base(file),
+		Path:   file,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Size:   size,
+	}, nil
+}
+
+// write writes the checksums of the artifacts in the sha256sum format and
+// the manifest into dir.
+func (m *Manifest) write(dir string) error {
+	checksums := ""
+	for _, a := range m.Artifacts {
+		checksums += fmt.Sprintf("%s  %s\n", a.SHA256, a.Name)
+	}
+	file := path.Join(filepath.ToSlash(dir), m.Name+"-"+m.Version+"-checksums.txt")
+	if err := ioutil.WriteFile(file, []byte(checksums), 0644); err != nil {
+		return err
+	}
+	a, err := makeArtifact(file)
+	if err != nil {
+		return err
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), append(content, '\n'), 0644)
+}
+
+// ReadManifest reads the manifest of a release written into dir.
+func ReadManifest(dir string) (Manifest, error) {
+	var m Manifest
+	content, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(content, &m)
+	return m, err
+}
@@ -0,0 +1,103 @@
+package building
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestReleaseArchiveName(t *testing.T) {
+	r := b.Release("./cmd/hello").WithVersion("1.2.3")
+	name, err := r.archiveName("linux", "amd64")
+	assert.NilError(t, err)
+	assert.Equal(t, name, "hello-1.2.3-linux-amd64")
+	name, err = r.WithTemplate("{{.Name}}_{{.OS}}").archiveName("darwin", "arm64")
+	assert.NilError(t, err)
+	assert.Equal(t, name, "hello_darwin")
+	_, err = r.WithTemplate("{{.Missing}}").archiveName("linux", "amd64")
+	assert.ErrorContains(t, err, "Missing")
+}
+
+func TestRelease(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds binaries")
+	}
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile("main.go", "package main\n\nvar version string\n\nfunc main() { println(version) }\n"),
+		fs.WithFile("LICENSE", "license"))
+	defer rootDirectory.Remove()
+	defer useBuilder()()
+
+	output := filepath.Join(rootDirectory.Path(), "dist")
+	m := b.Release(filepath.Join(rootDirectory.Path(), "main.go")).
+		WithName("hello").
+		WithVersion("1.2.3").
+		WithVersionVar("main.version").
+		WithOutput(output).
+		WithPlatforms("linux/amd64", "windows/amd64").
+		WithFiles(filepath.Join(rootDirectory.Path(), "LICENSE")).
+		Run()
+	assert.Equal(t, m.Name, "hello")
+	assert.Equal(t, m.Version, "1.2.3")
+	assert.Equal(t, len(m.Artifacts), 3)
+	assert.Equal(t, m.Artifacts[0].Name, "hello-1.2.3-linux-amd64.tar.gz")
+	assert.Equal(t, m.Artifacts[0].OS, "linux")
+	assert.Equal(t, m.Artifacts[1].Name, "hello-1.2.3-windows-amd64.zip")
+	assert.Equal(t, m.Artifacts[1].Arch, "amd64")
+	assert.Equal(t, m.Artifacts[2].Name, "hello-1.2.3-checksums.txt")
+
+	assert.DeepEqual(t, tarNames(t, m.Artifacts[0].Path), []string{"LICENSE", "hello"})
+	assert.DeepEqual(t, zipNames(t, m.Artifacts[1].Path), []string{"LICENSE", "hello.exe"})
+	files, err := ioutil.ReadDir(output)
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 4)
+
+	checksums, err := ioutil.ReadFile(m.Artifacts[2].Path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(checksums),
+		m.Artifacts[0].SHA256+"  hello-1.2.3-linux-amd64.tar.gz\n"+
+			m.Artifacts[1].SHA256+"  hello-1.2.3-windows-amd64.zip\n")
+	manifest, err := ReadManifest(output)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, manifest, m)
+}
+
+func tarNames(t *testing.T, file string) []string {
+	f, err := os.Open(file)
+	assert.NilError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NilError(t, err)
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, strings.TrimPrefix(hdr.Name, "./"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func zipNames(t *testing.T, file string) []string {
+	r, err := zip.OpenReader(file)
+	assert.NilError(t, err)
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
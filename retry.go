@@ -0,0 +1,89 @@
+package building
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type retry struct {
+	attempts int
+	backoff  time.Duration
+	pattern  *regexp.Regexp
+}
+
+// execute runs the command made by cmd, sending its error output to stderr
+// or os.Stderr if nil, retrying as configured, and fails the build with the
+// error output if any unless success is set.
+func execute(cmd func() *exec.Cmd, success bool, stderr io.Writer, r retry) int {
+	return attempt(func(stderr io.Writer) (int, error) {
+		c := cmd()
+		c.Stderr = stderr
+		return run(c, true)
+	}, success, stderr, r)
+}
+
+// attempt is execute for any function returning an exit code.
+func attempt(fn func(stderr io.Writer) (int, error), success bool, stderr io.Writer, r retry) int {
+	if r.pattern != nil && stderr == nil {
+		stderr = os.Stderr
+	}
+	var codes []string
+	delay := r.backoff
+	for attempt := 0; ; attempt++ {
+		captured := &bytes.Buffer{}
+		w := io.Writer(os.Stderr)
+		if stderr != nil {
+			w = io.MultiWriter(stderr, captured)
+		}
+		code, err := fn(w)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		if code == 0 {
+			return 0
+		}
+		codes = append(codes, fmt.Sprint(code))
+		msg := strings.TrimSpace(captured.String())
+		if attempt < r.attempts && (r.pattern == nil || r.pattern.MatchString(msg)) {
+			b.Printf("attempt %d failed with exit code %d, retrying in %s", attempt+1, code, delay)
+			time.Sleep(delay)
+			delay *= 2
+			continue
+		}
+		if success {
+			return code
+		}
+		err = fmt.Errorf("exit status %d", code)
+		if len(codes) > 1 {
+			err = fmt.Errorf("exit status %d after %d attempts (exit codes %s)", code, len(codes), strings.Join(codes, ", "))
+		}
+		if msg != "" {
+			b.Fatalf("%s: %s", err, msg)
+		}
+		b.Fatalln(err)
+	}
+}
+
+// replay buffers input if retrying, so that each attempt reads it again from
+// the start.
+func (r retry) replay(input io.Reader) func() io.Reader {
+	if r.attempts == 0 || input == nil {
+		return func() io.Reader {
+			return input
+		}
+	}
+	content, err := ioutil.ReadAll(input)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	return func() io.Reader {
+		return bytes.NewReader(content)
+	}
+}
@@ -0,0 +1,70 @@
+package building
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestRetry(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	buf := &bytes.Buffer{}
+	file := filepath.Join(rootDirectory.Path(), "count")
+	code := helper().WithStderr(buf).WithRetry(3, time.Millisecond).Run(helperArgs("-flaky", file, "2")...)
+	assert.Equal(t, code, 0)
+	assert.Equal(t, buf.String(), "flaky 1\nflaky 2\n")
+}
+
+func TestRetryReplaysInput(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	buf := &bytes.Buffer{}
+	file := filepath.Join(rootDirectory.Path(), "count")
+	code := helper().WithInput(strings.NewReader("foo\n")).WithOutput(buf).WithStderr(&bytes.Buffer{}).
+		WithRetry(3, time.Millisecond).Run(helperArgs("-cat", "-flaky", file, "2")...)
+	assert.Equal(t, code, 0)
+	assert.Equal(t, buf.String(), "foo\nfoo\nfoo\n")
+}
+
+func TestRetryOn(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	file := filepath.Join(rootDirectory.Path(), "count")
+	code := helper().WithStderr(&bytes.Buffer{}).WithRetry(3, time.Millisecond).WithRetryOn("flaky").
+		Run(helperArgs("-flaky", file, "2")...)
+	assert.Equal(t, code, 0)
+}
+
+func TestRetryOnMismatch(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	file := filepath.Join(rootDirectory.Path(), "count")
+	code := helper().WithStderr(&bytes.Buffer{}).WithSuccess().WithRetry(3, time.Millisecond).WithRetryOn("timeout").
+		Run(helperArgs("-flaky", file, "2")...)
+	assert.Equal(t, code, 1)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root")
+	defer rootDirectory.Remove()
+
+	buf := &bytes.Buffer{}
+	defer captureLog(buf)()
+	defer func() {
+		_, ok := recover().(failure)
+		assert.Assert(t, ok)
+		assert.Assert(t, strings.Contains(buf.String(), "exit status 3 after 3 attempts (exit codes 1, 2, 3): flaky 3\n"), buf.String())
+	}()
+	file := filepath.Join(rootDirectory.Path(), "count")
+	helper().WithStderr(&bytes.Buffer{}).WithRetry(2, time.Millisecond).Run(helperArgs("-flaky", file, "5")...)
+}
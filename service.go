@@ -0,0 +1,239 @@
+package building
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceTimeout is the default time a service has to become ready.
+var ServiceTimeout = time.Minute
+
+// Service is a tool or command running in the background, for instance a
+// database needed by integration tests.
+// Services get stopped when the target starting them finishes or when the
+// build fails.
+type Service struct {
+	name     string
+	cmd      *exec.Cmd
+	success  bool
+	kill     func()
+	captured *bytes.Buffer
+	logs     *logs
+	timeout  time.Duration
+	done     chan struct{}
+	err      error
+	once     sync.Once
+}
+
+func (b *B) start(name string, cmd *exec.Cmd, success bool, stderr io.Writer, kill func()) *Service {
+	s, err := start(name, cmd, success, stderr, kill)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	if b != nil {
+		b.mutex.Lock()
+		b.services = append(b.services, s)
+		b.mutex.Unlock()
+	}
+	return s
+}
+
+func start(name string, cmd *exec.Cmd, success bool, stderr io.Writer, kill func()) (*Service, error) {
+	s := &Service{
+		name:     name,
+		cmd:      cmd,
+		success:  success,
+		kill:     kill,
+		captured: redirect(cmd, stderr),
+		logs:     &logs{},
+		timeout:  ServiceTimeout,
+		done:     make(chan struct{}),
+	}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, s.logs)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, s.logs)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		s.err = cmd.Wait()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// WithTimeout sets the time the service has to become ready.
+func (s *Service) WithTimeout(timeout time.Duration) *Service {
+	s.timeout = timeout
+	return s
+}
+
+// WaitPort waits until a TCP connection can be opened to address.
+func (s *Service) WaitPort(address string) *Service {
+	b.Assert(s.waitPort(address))
+	return s
+}
+
+// WaitLog waits until the output of the service matches pattern.
+func (s *Service) WaitLog(pattern string) *Service {
+	b.Assert(s.waitLog(pattern))
+	return s
+}
+
+// WaitHTTP waits until a GET request to url returns 200.
+func (s *Service) WaitHTTP(url string) *Service {
+	b.Assert(s.waitHTTP(url))
+	return s
+}
+
+func (s *Service) waitPort(address string) error {
+	return s.wait("port "+address, func() bool {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err != nil {
+			return false
+		}
+		b.Close(conn)
+		return true
+	})
+}
+
+func (s *Service) waitLog(pattern string) error {
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return err
+	}
+	return s.wait("log "+pattern, func() bool {
+		return re.MatchString(s.logs.String())
+	})
+}
+
+func (s *Service) waitHTTP(url string) error {
+	client := http.Client{Timeout: time.Second}
+	return s.wait("url "+url, func() bool {
+		resp, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		b.Close(resp.Body)
+		return resp.StatusCode == http.StatusOK
+	})
+}
+
+func (s *Service) wait(desc string, ready func() bool) error {
+	b.Debugln("waiting for", s.name, desc)
+	deadline := time.Now().Add(s.timeout)
+	for {
+		if ready() {
+			return nil
+		}
+		select {
+		case <-s.done:
+			return fmt.Errorf("%s exited before %s was ready: %v%s", s.name, desc, s.err, s.errors())
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not ready after %s waiting for %s", s.name, s.timeout, desc)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *Service) errors() string {
+	if msg := strings.TrimSpace(s.captured.String()); msg != "" {
+		return ": " + msg
+	}
+	return ""
+}
+
+// Wait waits for the service to exit and returns its exit code.
+func (s *Service) Wait() int {
+	<-s.done
+	code, err := exitCode(s.err, s.success)
+	if err != nil {
+		b.Fatalf("%s: %s%s", s.name, err, s.errors())
+	}
+	return code
+}
+
+// Stop terminates the service.
+func (s *Service) Stop() {
+	s.once.Do(func() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		b.Println("stopping", s.name)
+		if s.kill != nil {
+			s.kill()
+		} else if err := s.cmd.Process.Signal(os.Interrupt); err == nil {
+			select {
+			case <-s.done:
+			case <-time.After(5 * time.Second):
+				b.Check(s.cmd.Process.Kill())
+			}
+		} else {
+			b.Check(s.cmd.Process.Kill())
+		}
+		<-s.done
+	})
+}
+
+// stopServices stops the services started after the first n ones, in
+// reverse order.
+func (b *B) stopServices(n int) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	if n > len(b.services) {
+		n = len(b.services)
+	}
+	services := b.services[n:]
+	b.services = b.services[:n]
+	b.mutex.Unlock()
+	for i := len(services) - 1; i >= 0; i-- {
+		services[i].Stop()
+	}
+}
+
+func (b *B) countServices() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.services)
+}
+
+type logs struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (l *logs) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logs) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.String()
+}
+
+func randomID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		b.Fatalln(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,93 @@
+package building
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func startHelper(t *testing.T, args ...string) *Service {
+	c := helper().WithOutput(&bytes.Buffer{}).WithStderr(&bytes.Buffer{})
+	s, err := start("helper", c.command(helperArgs(args...)), false, c.stderr, nil)
+	assert.NilError(t, err)
+	return s
+}
+
+func TestServiceWaitLog(t *testing.T) {
+	s := startHelper(t, "starting", "-sleep", "200ms", "listening on 1234", "-sleep", "1m")
+	defer s.Stop()
+	assert.NilError(t, s.waitLog(`^listening on \d+$`))
+}
+
+func TestServiceWaitPort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+	s := startHelper(t, "-sleep", "1m")
+	defer s.Stop()
+	assert.NilError(t, s.waitPort(l.Addr().String()))
+}
+
+func TestServiceWaitHTTP(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	s := startHelper(t, "-sleep", "1m")
+	defer s.Stop()
+	assert.NilError(t, s.waitHTTP(server.URL))
+	assert.Equal(t, atomic.LoadInt32(&requests), int32(2))
+}
+
+func TestServiceExitedBeforeReady(t *testing.T) {
+	s := startHelper(t, "-stderr", "oops", "-exit", "1")
+	err := s.waitLog("never")
+	assert.Error(t, err, "helper exited before log never was ready: exit status 1: oops")
+}
+
+func TestServiceTimeout(t *testing.T) {
+	s := startHelper(t, "-sleep", "1m").WithTimeout(200 * time.Millisecond)
+	defer s.Stop()
+	err := s.waitLog("never")
+	assert.Error(t, err, "helper not ready after 200ms waiting for log never")
+}
+
+func TestServiceWait(t *testing.T) {
+	s := startHelper(t, "foo")
+	assert.Equal(t, s.Wait(), 0)
+	s.Stop()
+}
+
+func TestServiceStop(t *testing.T) {
+	s := startHelper(t, "-sleep", "1m")
+	start := time.Now()
+	s.Stop()
+	assert.Assert(t, time.Since(start) < 10*time.Second)
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("service still running")
+	}
+}
+
+func TestStopServices(t *testing.T) {
+	b := &B{}
+	s1 := b.start("helper", helper().command(helperArgs("-sleep", "1m")), false, nil, nil)
+	n := b.countServices()
+	s2 := b.start("helper", helper().command(helperArgs("-sleep", "1m")), false, nil, nil)
+	b.stopServices(n)
+	<-s2.done
+	assert.Equal(t, b.countServices(), 1)
+	b.stopServices(0)
+	<-s1.done
+	assert.Equal(t, b.countServices(), 0)
+}
@@ -100,6 +100,10 @@ func untarFiles(src, dst string) error {
 			r = gz
 		}
 	}
+	return untar(r, dst)
+}
+
+func untar(r io.Reader, dst string) error {
 	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
@@ -121,6 +125,12 @@ func untarFiles(src, dst string) error {
 		if err = os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+			continue
+		}
 		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
 		if err != nil {
 			return err
@@ -1,18 +1,24 @@
 package building
 
 import (
-	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 var (
@@ -21,30 +27,60 @@ var (
 	containers = flag.Bool("containers", false, "always build in containers")
 	cross      = flag.Bool("cross", false, "build for all platforms (linux, darwin, windows)")
 	parallel   = flag.Bool("parallel", false, "build in parallel")
+	pull       = flag.Bool("pull", false, "always pull base images and rebuild tool images")
+	offline    = flag.Bool("offline", false, "never build tool images, see save-images and load-images")
+)
+
+const (
+	hashLabel = "brique.hash"
+	// basesLabel lists the base images of a tool image, exported along
+	// with it by save-images.
+	basesLabel = "brique.bases"
 )
 
 func init() {
 	// Manual flags parsing to enable containers before any actual work
 	for _, arg := range os.Args {
-		if arg == "-containers" {
+		switch arg {
+		case "-containers":
 			*containers = true
-			return
+		case "-pull":
+			*pull = true
+		case "-offline":
+			*offline = true
 		}
 	}
 }
 
 type Tool struct {
 	root         string
+	module       bool
+	moduleDir    string
 	name         string
 	url          string
+	check        string
+	constraint   string
+	extract      func(string) string
+	downloads    []download
+	path         string
 	dir          string
 	env          []string
+	passEnv      []string
+	ports        []string
+	instance     string
 	instructions string
+	contexts     []string
+	buildArgs    []string
+	packages     []string
+	binaries     []string
 	names        string
 	container    bool
+	detected     bool
 	output       io.Writer
+	stderr       io.Writer
 	input        io.Reader
 	success      bool
+	retry        retry
 }
 
 func (t Tool) WithDir(dir string) Tool {
@@ -64,11 +100,32 @@ func (t Tool) WithEnv(env ...string) Tool {
 	return t
 }
 
+// WithPassEnv forwards environment variables by name when the tool runs in a
+// container.
+func (t Tool) WithPassEnv(names ...string) Tool {
+	t.passEnv = append(t.passEnv, names...)
+	return t
+}
+
+// WithPort publishes a port as "host:container" when the tool runs in a
+// container.
+func (t Tool) WithPort(port string) Tool {
+	t.ports = append(t.ports, port)
+	return t
+}
+
 func (t Tool) WithOutput(w io.Writer) Tool {
 	t.output = w
 	return t
 }
 
+// WithStderr redirects the error output, which then gets included in the
+// failure message if the tool fails.
+func (t Tool) WithStderr(w io.Writer) Tool {
+	t.stderr = w
+	return t
+}
+
 func (t Tool) WithInput(r io.Reader) Tool {
 	t.input = r
 	return t
@@ -79,16 +136,140 @@ func (t Tool) WithSuccess() Tool {
 	return t
 }
 
+// WithVersion requires the locally installed tool to satisfy a constraint
+// such as ">=1.10,<1.12", otherwise the tool runs in a container.
+// extract retrieves the version from the output of the tool check, if nil
+// the first dotted number found is used.
+func (t Tool) WithVersion(constraint string, extract func(output string) string) Tool {
+	if _, err := parseConstraint(constraint); err != nil {
+		b.Fatalln(err)
+	}
+	t.constraint = constraint
+	t.extract = extract
+	t.detected = false
+	return t
+}
+
+// WithDownload provides a binary of the tool for a platform such as
+// "linux/amd64", used when the tool is not installed locally.
+// url points to either the binary itself or a zip or tar archive containing
+// it, with sum its sha256 checksum.
+func (t Tool) WithDownload(platform, url, sum string) Tool {
+	t.downloads = append(t.downloads, download{
+		platform: platform,
+		url:      url,
+		sum:      strings.ToLower(sum),
+	})
+	t.detected = false
+	return t
+}
+
+// WithRetry runs the tool again up to n times while it fails, waiting for
+// backoff before the first retry and doubling it each time.
+// The input of the tool is replayed, apart from the standard input.
+func (t Tool) WithRetry(n int, backoff time.Duration) Tool {
+	t.retry.attempts = n
+	t.retry.backoff = backoff
+	return t
+}
+
+// WithRetryOn only retries if the error output of the tool matches pattern.
+func (t Tool) WithRetryOn(pattern string) Tool {
+	t.retry.pattern = regexp.MustCompile(pattern)
+	return t
+}
+
+// WithBuildArg sets a build argument of the image of the tool.
+func (t Tool) WithBuildArg(name, value string) Tool {
+	t.buildArgs = append(t.buildArgs, name+"="+value)
+	return t
+}
+
+// WithPackages declares the alpine packages installing the tool, so that
+// adding it to the image of another alpine based tool only adds them, see
+// WithTool. On top of another image its binaries get copied instead.
+func (t Tool) WithPackages(packages ...string) Tool {
+	t.packages = append(t.packages, packages...)
+	return t
+}
+
+// WithBinaries declares the files or folders installing the tool in its
+// image, so that adding it to the image of another tool copies them over,
+// see WithTool.
+func (t Tool) WithBinaries(paths ...string) Tool {
+	t.binaries = append(t.binaries, paths...)
+	return t
+}
+
+// WithTool makes tool available when t runs in a container by adding it on
+// top of the image of t, either by installing its packages or by copying
+// its binaries from a stage building its own image.
 func (t Tool) WithTool(tool Tool) Tool {
-	t.instructions += "\n" + tool.instructions
+	t = t.detect()
+	tool = tool.detect()
 	if t.container || tool.container {
+		instructions, err := composeInstructions(t.instructions, tool)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		t.instructions = instructions
 		t.container = true
-		t.names += "-" + tool.name
-		t.buildImage()
+		t.names += "-" + tool.names
 	}
+	t.contexts = append(t.contexts, tool.contexts...)
+	t.buildArgs = append(t.buildArgs, tool.buildArgs...)
+	t.packages = append(t.packages, tool.packages...)
+	t.binaries = append(t.binaries, tool.binaries...)
 	return t
 }
 
+// composeInstructions adds tool to the image built by instructions.
+// Packages only get installed on top of an alpine image, otherwise the
+// binaries get copied over.
+func composeInstructions(instructions string, tool Tool) (string, error) {
+	if len(tool.packages) == 0 && len(tool.binaries) == 0 {
+		return "", fmt.Errorf("%s cannot be added to the image of another tool, see WithPackages and WithBinaries", tool.name)
+	}
+	instructions = strings.TrimSpace(instructions)
+	base := finalBase(instructions)
+	alpine := strings.Contains(base, "alpine")
+	if !alpine && len(tool.binaries) == 0 {
+		return "", fmt.Errorf("%s cannot be added to the image of another tool based on %s which is not alpine, see WithBinaries", tool.name, base)
+	}
+	if len(tool.binaries) > 0 {
+		stage := "brique-" + strings.ToLower(tool.name)
+		for i := 2; hasStage(instructions, stage); i++ {
+			stage = fmt.Sprintf("brique-%s-%d", strings.ToLower(tool.name), i)
+		}
+		copies := ""
+		for _, path := range tool.binaries {
+			copies += "\nCOPY --from=" + stage + " " + path + " " + path
+		}
+		instructions = nameStage(strings.TrimSpace(tool.instructions), stage) + "\n" + instructions + copies
+	}
+	if len(tool.packages) > 0 && alpine {
+		instructions += "\nRUN apk add --no-cache " + strings.Join(tool.packages, " ")
+	}
+	return instructions, nil
+}
+
+// nameStage names the last stage of instructions.
+func nameStage(instructions, name string) string {
+	lines := strings.Split(instructions, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		fields := strings.Fields(lines[i])
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		if n := len(fields); n > 3 && strings.EqualFold(fields[n-2], "AS") {
+			fields = fields[:n-2]
+		}
+		lines[i] = strings.Join(append(fields, "AS", name), " ")
+		break
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (b *B) MakeTool(name, check, url, instructions string, args ...string) Tool {
 	t := b.makeTool(name, check, url, instructions)
 	if len(args) > 0 {
@@ -97,6 +278,23 @@ func (b *B) MakeTool(name, check, url, instructions string, args ...string) Tool
 	return t
 }
 
+// MakeToolFromDockerfile makes a tool whose image gets built from the
+// dockerfile at path with the folder contextDir as build context, both
+// relative to the current directory.
+// The files listed in the .dockerignore of the context are left out.
+func (b *B) MakeToolFromDockerfile(name, path, contextDir string) Tool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	if _, err := os.Stat(contextDir); err != nil {
+		b.Fatalln(err)
+	}
+	t := b.makeTool(name, "", "", string(content))
+	t.contexts = []string{contextDir}
+	return t
+}
+
 func (b *B) makeTool(name, check, url, instructions string) Tool {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
@@ -105,22 +303,106 @@ func (b *B) makeTool(name, check, url, instructions string) Tool {
 	}
 	t := Tool{
 		root:         b.root,
+		module:       b.module,
+		moduleDir:    b.moduleDir,
 		name:         name,
 		url:          url,
+		check:        check,
 		instructions: instructions,
 		names:        name,
-		container:    noApplication(name, check),
 	}
-	if t.container && name != "" && url != "" && !*containers {
-		b.Print("missing " + name + ": consider installing it to speed up the build, see " + url)
+	b.tools[name] = t
+	return t
+}
+
+// detect decides once per tool, constraint and downloads whether to run it locally or
+// in a container.
+func (t Tool) detect() Tool {
+	if t.detected {
+		return t
 	}
-	if t.container {
-		t.buildImage()
+	key := t.name + " " + t.constraint
+	for _, d := range t.downloads {
+		key += " " + d.platform + "=" + d.sum
 	}
-	b.tools[name] = t
+	b.mutex.Lock()
+	d, ok := b.detections[key]
+	if !ok {
+		d = &detector{}
+		b.detections[key] = d
+	}
+	b.mutex.Unlock()
+	// locating may download the tool or fail, so it must not hold b.mutex
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.detection == nil {
+		located := t.locate()
+		d.detection = &located
+	}
+	t.container = d.detection.container
+	t.path = d.detection.path
+	t.detected = true
 	return t
 }
 
+type detection struct {
+	container bool
+	path      string
+}
+
+// detector locates a tool once, see detect.
+type detector struct {
+	mutex     sync.Mutex
+	detection *detection
+}
+
+func (t Tool) locate() detection {
+	if *containers {
+		return detection{container: true}
+	}
+	output, found := checkApplication(t.name, t.check)
+	missing := t.name
+	if found {
+		version, ok := t.satisfied(output)
+		if ok {
+			return detection{}
+		}
+		missing += " " + t.constraint + " (found " + version + ")"
+	}
+	if path := t.download(); path != "" {
+		if t.constraint == "" {
+			return detection{path: path}
+		}
+		output, _ := checkApplication(path, t.check)
+		version, ok := t.satisfied(output)
+		if ok {
+			return detection{path: path}
+		}
+		missing = t.name + " " + t.constraint + " (downloaded " + version + ")"
+	}
+	if t.instructions == "" {
+		b.Fatalln("missing", missing+", see", t.url)
+	}
+	if t.name != "" && t.url != "" {
+		b.Print("missing " + missing + ": consider installing it to speed up the build, see " + t.url)
+	}
+	return detection{container: true}
+}
+
+// satisfied returns the version of the tool from the output of its check
+// and whether it satisfies the constraint.
+func (t Tool) satisfied(output string) (string, bool) {
+	version := extractVersion(output)
+	if t.extract != nil {
+		version = t.extract(output)
+	}
+	ok, err := satisfies(version, t.constraint)
+	if err != nil {
+		b.Fatalln(err)
+	}
+	return version, ok
+}
+
 func (b *B) WithOS(f func(goos string)) {
 	platforms := []string{runtime.GOOS}
 	if *cross {
@@ -142,96 +424,340 @@ func (b *B) WithOS(f func(goos string)) {
 	wg.Wait()
 }
 
-func noApplication(name, check string) bool {
+func checkApplication(name, check string) (string, bool) {
 	b.Debugln("checking for", name)
 	cmd := exec.Command(name)
 	if check != "" {
 		cmd = exec.Command(name, check)
 	}
-	err := cmd.Run()
+	output, err := cmd.CombinedOutput()
 	if err == nil {
-		return *containers
+		return string(output), true
 	}
 	_, ok := err.(*exec.ExitError)
-	return !ok
+	return string(output), ok
 }
 
 func (t Tool) buildImage() {
-	b.Println("preparing image for", t.name)
-	buf := &bytes.Buffer{}
-	tarFile(t.instructions, "Dockerfile", buf)
-	cmd := exec.Command("docker", "build", "-t", t.image(), "-")
-	cmd.Stderr = os.Stderr
-	if isDebug() {
-		cmd.Stdout = os.Stdout
+	b.imagesMutex.Lock()
+	defer b.imagesMutex.Unlock()
+	image := t.image()
+	if _, ok := b.images[image]; ok {
+		return
+	}
+	if *pull && *offline {
+		b.Fatalln("-pull and -offline cannot be used together")
+	}
+	r := containerEngine()
+	if api := r.engine(); api != nil && api.windows() {
+		b.Fatalln("docker is in Windows containers mode, switch it to Linux containers to run", t.name)
 	}
-	cmd.Stdin = buf
-	if err := cmd.Run(); err != nil {
+	context := &bytes.Buffer{}
+	if err := toolContext(t.contexts, t.instructions, context); err != nil {
 		b.Fatalln(err)
 	}
+	bases := t.bases(r)
+	hash := t.hash(bases, context.Bytes())
+	if !*pull && r.imageLabel(image, hashLabel) == hash {
+		b.Debugln("image up to date for", t.name)
+		b.images[image] = hash
+		return
+	}
+	if *offline {
+		missing := []string{image}
+		for _, base := range bases {
+			if strings.HasSuffix(base, "@") {
+				missing = append(missing, strings.TrimSuffix(base, "@"))
+			}
+		}
+		b.Fatalln("offline mode: missing or outdated images for", t.name+":", strings.Join(missing, " ")+", import them with load-images")
+	}
+	b.Println("preparing image for", t.name)
+	c := containerBuild{
+		tags:       []string{image},
+		context:    "-",
+		dockerfile: ".dockerfile",
+		labels:     []string{hashLabel + "=" + hash, basesLabel + "=" + strings.Join(t.baseNames(), " ")},
+		args:       t.buildArgs,
+	}
+	if api := r.engine(); api != nil {
+		b.buildEngine(api, c, context)
+	} else if r.stdin {
+		b.runEngine(r, r.buildArgs(c), context)
+	} else {
+		dir, err := ioutil.TempDir("", "brique")
+		if err != nil {
+			b.Fatalln(err)
+		}
+		defer func() {
+			b.Check(os.RemoveAll(dir))
+		}()
+		if err := untar(context, dir); err != nil {
+			b.Fatalln(err)
+		}
+		c.context, c.dockerfile = dir, filepath.Join(dir, c.dockerfile)
+		b.runEngine(r, r.buildArgs(c), nil)
+	}
+	b.images[image] = hash
 }
 
-func (t Tool) image() string {
-	if t.root == "" {
-		b.Fatalln("missing root")
+// bases returns the base images of the tool along with their ids as
+// "image@id", pulling them first if missing or with -pull so that the image
+// built from them gets labelled with their actual ids.
+func (t Tool) bases(r containerRuntime) []string {
+	var bases []string
+	for _, base := range t.baseNames() {
+		id := r.imageID(base)
+		if !*offline && (*pull || id == "") {
+			b.Println("pulling", base)
+			b.pullImage(r, base)
+			id = r.imageID(base)
+		}
+		bases = append(bases, base+"@"+id)
 	}
-	return strings.Replace(t.root, "/", "-", -1) + "-build-" + t.names
+	return bases
 }
 
-func tarFile(content, filename string, writer io.Writer) {
-	tw := tar.NewWriter(writer)
-	hdr := &tar.Header{
-		Name: filename,
-		Mode: 0600,
-		Size: int64(len(content)),
+// baseNames returns the distinct base images of the tool.
+func (t Tool) baseNames() []string {
+	var names []string
+	for _, base := range baseImages(t.instructions) {
+		if !contains(names, base) {
+			names = append(names, base)
+		}
 	}
-	if err := tw.WriteHeader(hdr); err != nil {
-		b.Fatal(err)
+	return names
+}
+
+// hash identifies the image content with its instructions, build arguments
+// and context, and the actual base images it derives from.
+func (t Tool) hash(bases []string, context []byte) string {
+	instructions := t.instructions
+	for _, arg := range t.buildArgs {
+		instructions += "\n--build-arg " + arg
+	}
+	if len(t.contexts) > 0 {
+		digest, err := contextDigest(context)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		instructions += "\n--context " + digest
+	}
+	return imageHash(instructions, bases)
+}
+
+func imageHash(instructions string, bases []string) string {
+	h := sha256.New()
+	io.WriteString(h, instructions)
+	for _, base := range bases {
+		io.WriteString(h, "\n"+base)
 	}
-	if _, err := tw.Write([]byte(content)); err != nil {
-		b.Fatal(err)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// baseImages returns the images referenced by the FROM instructions,
+// ignoring the previous build stages.
+func baseImages(instructions string) []string {
+	var images []string
+	stages := map[string]bool{"scratch": true}
+	for _, line := range strings.Split(instructions, "\n") {
+		image, stage, ok := parseFrom(line)
+		if !ok {
+			continue
+		}
+		if !stages[image] {
+			images = append(images, image)
+		}
+		if stage != "" {
+			stages[stage] = true
+		}
 	}
-	if err := tw.Close(); err != nil {
-		b.Fatal(err)
+	return images
+}
+
+// finalBase returns the image the last stage of instructions is based on,
+// following the stages it builds upon.
+func finalBase(instructions string) string {
+	base := ""
+	stages := map[string]string{}
+	for _, line := range strings.Split(instructions, "\n") {
+		image, stage, ok := parseFrom(line)
+		if !ok {
+			continue
+		}
+		if s, ok := stages[strings.ToLower(image)]; ok {
+			image = s
+		}
+		base = image
+		if stage != "" {
+			stages[strings.ToLower(stage)] = image
+		}
 	}
+	return base
 }
 
+// hasStage tells whether instructions already have a stage named name.
+func hasStage(instructions, name string) bool {
+	for _, line := range strings.Split(instructions, "\n") {
+		if _, stage, ok := parseFrom(line); ok && strings.EqualFold(stage, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFrom returns the image and the optional stage name of a FROM line.
+func parseFrom(line string) (string, string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+		return "", "", false
+	}
+	fields = fields[1:]
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+		return fields[0], fields[2], true
+	}
+	return fields[0], "", true
+}
+
+func (t Tool) image() string {
+	if t.root == "" {
+		b.Fatalln("missing root")
+	}
+	return imagePrefix(t.root) + t.names
+}
+
+func imagePrefix(root string) string {
+	return strings.Replace(root, "/", "-", -1) + "-build-"
+}
+
+// Run runs the tool and returns its exit code.
 func (t Tool) Run(args ...string) int {
-	if t.output == nil {
-		t.output = os.Stdout
+	t = t.detect()
+	t.print("running", args)
+	if api := t.engine(); api != nil {
+		return attempt(func(stderr io.Writer) (int, error) {
+			t.buildImage()
+			c, environ := t.containerRun(args)
+			stdout := t.output
+			if stdout == nil {
+				stdout = os.Stdout
+			}
+			b.Debugln("running", c.cmd, "in", c.image)
+			return api.run(c, environ, stdout, stderr)
+		}, t.success, t.stderr, t.retry)
+	}
+	input := t.retry.replay(t.input)
+	return execute(func() *exec.Cmd {
+		t.input = input()
+		return t.command(args)
+	}, t.success, t.stderr, t.retry)
+}
+
+// Args makes a pipeline stage running the tool with args, see Pipe.
+func (t Tool) Args(args ...string) Stage {
+	return Stage{
+		name:    t.name,
+		success: t.success,
+		stderr:  t.stderr,
+		command: func() *exec.Cmd {
+			t = t.detect()
+			t.print("running", args)
+			return t.command(args)
+		},
+	}
+}
+
+// Start runs the tool in the background, see Service.
+func (t Tool) Start(args ...string) *Service {
+	t = t.detect()
+	var kill func()
+	if t.container {
+		t.instance = "brique-" + randomID()
+		r := containerEngine()
+		kill = func() {
+			if api := r.engine(); api != nil {
+				b.Check(api.remove(t.instance))
+				return
+			}
+			b.Check(exec.Command(r.name, r.removeArgs(t.instance)...).Run())
+		}
 	}
 	if t.input == nil {
-		t.input = os.Stdin
+		t.input = strings.NewReader("")
 	}
-	t.print(args)
+	t.print("starting", args)
+	return b.start(t.name, t.command(args), t.success, t.stderr, kill)
+}
+
+// command prepares the execution of the tool either locally or in a
+// container.
+func (t Tool) command(args []string) *exec.Cmd {
+	var cmd *exec.Cmd
 	if t.container {
-		return t.runContainer(args)
+		t.buildImage()
+		cmd = t.containerCommand(args)
+	} else {
+		cmd = t.applicationCommand(args)
+	}
+	cmd.Stdout = t.output
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
 	}
-	return t.runApplication(args)
+	cmd.Stdin = t.input
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	return cmd
+}
+
+// Output runs the tool and returns its trimmed output and exit code.
+// The error output is captured unless redirected with WithStderr.
+func (t Tool) Output(args ...string) (string, int) {
+	return capture(t.stderr, func(stdout, stderr io.Writer) int {
+		return t.WithOutput(stdout).WithStderr(stderr).Run(args...)
+	})
+}
+
+// Lines runs the tool and returns its non empty output lines and exit code.
+func (t Tool) Lines(args ...string) ([]string, int) {
+	out, code := t.Output(args...)
+	return lines(out), code
 }
 
-func (t Tool) runApplication(args []string) int {
+// JSON runs the tool and decodes its output into v if it succeeds.
+func (t Tool) JSON(v interface{}, args ...string) int {
+	out, code := t.Output(args...)
+	if code == 0 {
+		decode(out, v)
+	}
+	return code
+}
+
+func (t Tool) applicationCommand(args []string) *exec.Cmd {
 	if t.dir != "" {
 		err := os.MkdirAll(t.dir, 0755)
 		if err != nil {
 			b.Fatal(err)
 		}
 	}
-	cmd := exec.Command(t.name, args...)
-	cmd.Dir = t.dir
-	cmd.Env = append(os.Environ(), t.env...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = t.output
-	cmd.Stdin = t.input
-	code, err := run(cmd, t.success)
-	if err != nil {
-		b.Fatal(err)
+	name := t.name
+	if t.path != "" {
+		name = t.path
 	}
-	return code
+	cmd := exec.Command(name, args...)
+	cmd.Dir = t.dir
+	cmd.Env = b.environ(t.env)
+	return cmd
 }
 
-func (t Tool) print(args []string) {
-	prefix := "running"
+func (t Tool) print(verb string, args []string) {
+	prefix := verb
 	if t.container {
 		prefix += " [container]"
 	}
@@ -241,42 +767,138 @@ func (t Tool) print(args []string) {
 	b.Println(prefix, append([]string{t.name}, args...))
 }
 
-func (t Tool) runContainer(args []string) int {
-	// $$$$ MAT error out if docker in windows containers mode
+// engine returns a client of the Docker Engine API if the tool runs in a
+// container without input, nil otherwise.
+// The standard input is only left out if it has nothing to provide, as the
+// command line forwards it.
+func (t Tool) engine() *dockerAPI {
+	if !t.container || t.input != nil || !stdinEmpty() {
+		return nil
+	}
+	return containerEngine().engine()
+}
+
+// stdinEmpty tells whether the standard input is closed or the null device,
+// rather than a terminal, a pipe or a file.
+func stdinEmpty() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return true
+	}
+	null, err := os.Stat(os.DevNull)
+	return err == nil && os.SameFile(info, null)
+}
+
+func (t Tool) containerCommand(args []string) *exec.Cmd {
+	c, environ := t.containerRun(args)
+	r := containerEngine()
+	arg := r.runArgs(c)
+	b.Debugln("running", append([]string{r.name}, arg...))
+	cmd := exec.Command(r.name, arg...)
+	cmd.Env = environ
+	return cmd
+}
+
+// containerRun describes running the tool in a container, along with the
+// environment the variables forwarded to the container come from.
+func (t Tool) containerRun(args []string) (containerRun, []string) {
 	wd, err := os.Getwd()
 	if err != nil {
 		b.Fatalln(err)
 	}
-	if t.root == "" {
-		b.Fatalln("missing root")
-	}
-	w := path.Join("/go/src", t.root, t.dir)
-	// $$$$ MAT create w if needed
-	var envs []string
-	for _, e := range t.env {
-		envs = append(envs, "-e", e)
+	src, dst := wd, t.mount()
+	if t.moduleDir != "" {
+		src = t.moduleDir
 	}
+	// $$$$ MAT create the working directory if needed
 	// $$$$ MAT use --net=none by default and allow to customize by tool
-	arg := append([]string{"run", "--rm", "-v", wd + ":" + w, "-w", w, "-i"}, envs...)
-	arg = append(arg, t.image(), t.name)
 	// $$$$ MAT try and replace wd in args with w ?
 	// $$$$ do the same with TEMPDIR -> /tmp, and mount it ? any dir ?
 	// $$$$ MAT if GOPATH set, mount it instead of wd ?
-	arg = append(arg, args...)
-	b.Debugln("running", append([]string{"docker"}, arg...))
-	cmd := exec.Command("docker", arg...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = t.output
-	cmd.Stdin = t.input
-	code, err := run(cmd, t.success)
-	if err != nil {
-		b.Fatalln(err)
+	// Values are passed through the environment of the runtime command to
+	// keep them out of its arguments.
+	env := append(b.loadedEnv(), t.env...)
+	return containerRun{
+		image:   t.image(),
+		name:    t.instance,
+		src:     src,
+		dst:     dst,
+		workdir: t.workdir(wd),
+		env:     append(envNames(env), t.passEnv...),
+		ports:   t.ports,
+		cmd:     append([]string{t.name}, args...),
+	}, append(os.Environ(), env...)
+}
+
+// mount returns where the project is mounted in a container, under GOPATH
+// unless using modules.
+func (t Tool) mount() string {
+	if t.module {
+		return "/src"
 	}
-	return code
+	if t.root == "" {
+		b.Fatalln("missing root")
+	}
+	return path.Join("/go/src", t.root)
+}
+
+// workdir returns the working directory in a container matching wd, the
+// module being mounted from its root.
+func (t Tool) workdir(wd string) string {
+	rel := "."
+	if t.moduleDir != "" {
+		r, err := filepath.Rel(t.moduleDir, wd)
+		if err != nil {
+			b.Fatalln(err)
+		}
+		rel = filepath.ToSlash(r)
+	}
+	return path.Join(t.mount(), rel, filepath.ToSlash(t.dir))
+}
+
+func capture(stderr io.Writer, run func(stdout, stderr io.Writer) int) (string, int) {
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	buf := &bytes.Buffer{}
+	code := run(buf, stderr)
+	return strings.TrimSpace(buf.String()), code
+}
+
+func lines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func decode(s string, v interface{}) {
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		b.Fatalln("failed to decode output:", err)
+	}
+}
+
+// redirect sends the error output of cmd to stderr or os.Stderr if nil,
+// returning a copy of what stderr receives.
+func redirect(cmd *exec.Cmd, stderr io.Writer) *bytes.Buffer {
+	captured := &bytes.Buffer{}
+	if stderr == nil {
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stderr = io.MultiWriter(stderr, captured)
+	}
+	return captured
 }
 
 func run(cmd *exec.Cmd, success bool) (int, error) {
-	if err := cmd.Run(); err != nil {
+	return exitCode(cmd.Run(), success)
+}
+
+func exitCode(err error, success bool) (int, error) {
+	if err != nil {
 		exit, ok := err.(*exec.ExitError)
 		if ok {
 			if success {
@@ -0,0 +1,165 @@
+package building
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	"gotest.tools/fs"
+)
+
+func TestBaseImages(t *testing.T) {
+	assert.DeepEqual(t, baseImages("FROM golang:1.10.3-alpine3.8"), []string{"golang:1.10.3-alpine3.8"})
+	assert.DeepEqual(t, baseImages(`
+FROM alpine:3.8
+RUN apk add --no-cache git`), []string{"alpine:3.8"})
+	assert.DeepEqual(t, baseImages(`
+from --platform=linux/amd64 golang:1.10.3 AS builder
+RUN go build -o /app .
+FROM scratch
+COPY --from=builder /app /app
+FROM builder`), []string{"golang:1.10.3"})
+	assert.Assert(t, baseImages("") == nil)
+	tool := Tool{instructions: "FROM golang:1.10.3 AS builder\nFROM alpine:3.8\nFROM golang:1.10.3"}
+	assert.DeepEqual(t, tool.baseNames(), []string{"golang:1.10.3", "alpine:3.8"})
+}
+
+func TestImageHash(t *testing.T) {
+	hash := imageHash("FROM alpine:3.8", []string{"alpine:3.8@sha256:1234"})
+	assert.Equal(t, len(hash), 64)
+	assert.Equal(t, hash, imageHash("FROM alpine:3.8", []string{"alpine:3.8@sha256:1234"}))
+	assert.Assert(t, hash != imageHash("FROM alpine:3.8", []string{"alpine:3.8@sha256:5678"}))
+	assert.Assert(t, hash != imageHash("FROM alpine:3.8\nRUN true", []string{"alpine:3.8@sha256:1234"}))
+}
+
+func TestLines(t *testing.T) {
+	assert.DeepEqual(t, lines("a\n\n b \r\nc"), []string{"a", "b", "c"})
+	assert.Assert(t, lines("") == nil)
+}
+
+func TestMakeToolFromDockerfile(t *testing.T) {
+	rootDirectory := fs.NewDir(t, "root",
+		fs.WithFile("Dockerfile", "FROM alpine:3.8\nARG VERSION\nCOPY tool.sh /usr/bin/tool"),
+		fs.WithDir("context", fs.WithFile("tool.sh", "#!/bin/sh")))
+	defer rootDirectory.Remove()
+	defer useBuilder()()
+	b.root = "github.com/mat007/brique"
+
+	context := filepath.Join(rootDirectory.Path(), "context")
+	tool := b.MakeToolFromDockerfile("tool", filepath.Join(rootDirectory.Path(), "Dockerfile"), context)
+	assert.Equal(t, tool.instructions, "FROM alpine:3.8\nARG VERSION\nCOPY tool.sh /usr/bin/tool")
+	assert.DeepEqual(t, tool.contexts, []string{context})
+
+	hash := func(tool Tool) string {
+		buf := &bytes.Buffer{}
+		assert.NilError(t, toolContext(tool.contexts, tool.instructions, buf))
+		return tool.hash(nil, buf.Bytes())
+	}
+	h := hash(tool)
+	assert.Equal(t, h, hash(tool))
+	assert.Assert(t, h != hash(tool.WithBuildArg("VERSION", "1.0.0")))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(context, "tool.sh"), []byte("#!/bin/bash"), 0755))
+	assert.Assert(t, h != hash(tool))
+
+	composed := b.MakeTool("other", "", "", "FROM alpine:3.8").
+		WithTool(tool.WithBuildArg("VERSION", "1.0.0").WithBinaries("/usr/bin/tool"))
+	assert.Assert(t, composed.container)
+	assert.Equal(t, composed.instructions, `FROM alpine:3.8 AS brique-tool
+ARG VERSION
+COPY tool.sh /usr/bin/tool
+FROM alpine:3.8
+COPY --from=brique-tool /usr/bin/tool /usr/bin/tool`)
+	assert.DeepEqual(t, composed.contexts, []string{context})
+	assert.DeepEqual(t, composed.buildArgs, []string{"VERSION=1.0.0"})
+}
+
+func TestComposeInstructions(t *testing.T) {
+	golang := "\nFROM golang:1.10.3-alpine3.8"
+	git := Tool{name: "git", instructions: "\nFROM alpine:3.8\nRUN apk add --no-cache git", packages: []string{"git"}}
+	instructions, err := composeInstructions(golang, git)
+	assert.NilError(t, err)
+	assert.Equal(t, instructions, `FROM golang:1.10.3-alpine3.8
+RUN apk add --no-cache git`)
+
+	dep := Tool{name: "dep", instructions: `FROM golang:1.10.3-alpine3.8
+RUN curl -o /usr/bin/dep -L https://github.com/golang/dep/releases/download/v0.4.1/dep-linux-amd64`, binaries: []string{"/usr/bin/dep"}}
+	instructions, err = composeInstructions(instructions, dep)
+	assert.NilError(t, err)
+	assert.Equal(t, instructions, `FROM golang:1.10.3-alpine3.8 AS brique-dep
+RUN curl -o /usr/bin/dep -L https://github.com/golang/dep/releases/download/v0.4.1/dep-linux-amd64
+FROM golang:1.10.3-alpine3.8
+RUN apk add --no-cache git
+COPY --from=brique-dep /usr/bin/dep /usr/bin/dep`)
+	assert.DeepEqual(t, baseImages(instructions), []string{"golang:1.10.3-alpine3.8", "golang:1.10.3-alpine3.8"})
+
+	_, err = composeInstructions(golang, Tool{name: "go", instructions: golang})
+	assert.Error(t, err, "go cannot be added to the image of another tool, see WithPackages and WithBinaries")
+}
+
+func TestComposeInstructionsNotAlpine(t *testing.T) {
+	golang := "FROM golang:1.10.3 AS builder\nFROM builder"
+	git := Tool{name: "git", instructions: "FROM alpine:3.8\nRUN apk add --no-cache git", packages: []string{"git"}}
+	_, err := composeInstructions(golang, git)
+	assert.Error(t, err, "git cannot be added to the image of another tool based on golang:1.10.3 which is not alpine, see WithBinaries")
+
+	git.binaries = []string{"/usr/bin/git"}
+	instructions, err := composeInstructions(golang, git)
+	assert.NilError(t, err)
+	assert.Equal(t, instructions, `FROM alpine:3.8 AS brique-git
+RUN apk add --no-cache git
+FROM golang:1.10.3 AS builder
+FROM builder
+COPY --from=brique-git /usr/bin/git /usr/bin/git`)
+}
+
+func TestComposeInstructionsTwice(t *testing.T) {
+	dep := Tool{name: "dep", instructions: "FROM alpine:3.8\nRUN build dep", binaries: []string{"/usr/bin/dep"}}
+	instructions, err := composeInstructions("FROM alpine:3.8", dep)
+	assert.NilError(t, err)
+	instructions, err = composeInstructions(instructions, dep)
+	assert.NilError(t, err)
+	assert.Equal(t, instructions, `FROM alpine:3.8 AS brique-dep-2
+RUN build dep
+FROM alpine:3.8 AS brique-dep
+RUN build dep
+FROM alpine:3.8
+COPY --from=brique-dep /usr/bin/dep /usr/bin/dep
+COPY --from=brique-dep-2 /usr/bin/dep /usr/bin/dep`)
+}
+
+func TestComposeMultiStageInstructions(t *testing.T) {
+	tool := Tool{name: "Tool", instructions: `FROM golang:1.10.3 AS builder
+RUN go build -o /tool .
+FROM --platform=linux/amd64 alpine:3.8 as final
+COPY --from=builder /tool /usr/bin/tool`, binaries: []string{"/usr/bin/tool"}}
+	instructions, err := composeInstructions("FROM alpine:3.8", tool)
+	assert.NilError(t, err)
+	assert.Equal(t, instructions, `FROM golang:1.10.3 AS builder
+RUN go build -o /tool .
+FROM --platform=linux/amd64 alpine:3.8 AS brique-tool
+COPY --from=builder /tool /usr/bin/tool
+FROM alpine:3.8
+COPY --from=brique-tool /usr/bin/tool /usr/bin/tool`)
+	assert.DeepEqual(t, baseImages(instructions), []string{"golang:1.10.3", "alpine:3.8", "alpine:3.8"})
+}
+
+func TestWithTool(t *testing.T) {
+	defer useBuilder()()
+	b.root = "github.com/mat007/brique"
+	defer func(c bool) { *containers = c }(*containers)
+	*containers = true
+
+	tool := b.Go().WithTool(b.Git()).WithTool(b.Jq())
+	assert.Assert(t, tool.container)
+	assert.Equal(t, tool.image(), "github.com-mat007-brique-build-go-git-jq")
+	assert.Equal(t, tool.instructions, `FROM golang:1.10.3-alpine3.8
+RUN apk add --no-cache git
+RUN apk add --no-cache jq`)
+
+	tool = b.Go().WithTool(b.Git().WithTool(b.Jq()))
+	assert.Equal(t, tool.image(), "github.com-mat007-brique-build-go-git-jq")
+	assert.Equal(t, tool.instructions, `FROM golang:1.10.3-alpine3.8
+RUN apk add --no-cache git jq`)
+}
@@ -0,0 +1,241 @@
+package building
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is a semantic version, see https://semver.org.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+	// Commits is the number of commits since the tag of the version.
+	Commits int
+	Dirty   bool
+	Commit  string
+	Time    time.Time
+}
+
+var semver = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// ParseVersion parses a semantic version, with an optional "v" prefix.
+func ParseVersion(s string) (Version, error) {
+	m := semver.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid semantic version %q", s)
+	}
+	var v Version
+	for i, n := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		var err error
+		if *n, err = strconv.Atoi(m[i+1]); err != nil {
+			return Version{}, fmt.Errorf("invalid semantic version %q", s)
+		}
+	}
+	v.Prerelease = m[4]
+	v.Build = m[5]
+	return v, nil
+}
+
+// Version returns the version of the project computed from the nearest
+// v* tag being a semantic version, v0.0.0 if none.
+// Untagged commits get a pseudo-version like Go modules do, and a dirty
+// working tree adds +dirty to the build metadata.
+func (b *B) Version() Version {
+	r := b.gitRepo()
+	if r == nil {
+		b.Fatalln("version: not a git repository")
+	}
+	defer r.close()
+	v, err := version(r)
+	if err != nil {
+		b.Fatalln("version:", err)
+	}
+	v.Dirty = b.GitDirty()
+	return v
+}
+
+func version(r *gitRepo) (Version, error) {
+	commit, _, err := r.head()
+	if err != nil {
+		return Version{}, err
+	}
+	tag, distance, err := r.describeFunc(commit, true, func(name string) bool {
+		_, err := ParseVersion(name)
+		return strings.HasPrefix(name, "v") && err == nil
+	})
+	if err != nil {
+		return Version{}, err
+	}
+	v := Version{}
+	if tag != "" {
+		if v, err = ParseVersion(tag); err != nil {
+			return Version{}, err
+		}
+		v.Commits = distance
+	} else {
+		v.Commits = -1
+	}
+	v.Commit = commit
+	v.Time, err = r.commitTime(commit)
+	return v, err
+}
+
+// Tagged returns true if the version comes from a tag on the commit.
+func (v Version) Tagged() bool {
+	return v.Commits == 0
+}
+
+// String returns the version without the "v" prefix, as a pseudo-version if
+// untagged.
+func (v Version) String() string {
+	s := ""
+	if v.Tagged() || v.Commit == "" {
+		s = v.base()
+	} else {
+		s = v.pseudo()
+	}
+	build := v.Build
+	if v.Dirty {
+		if build != "" {
+			build += "."
+		}
+		build += "dirty"
+	}
+	if build != "" {
+		s += "+" + build
+	}
+	return s
+}
+
+func (v Version) base() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// pseudo formats a version like Go modules pseudo-versions:
+// 0.0.0-yyyymmddhhmmss-abcdefabcdef when there is no tag,
+// x.y.(z+1)-0.yyyymmddhhmmss-abcdefabcdef after x.y.z and
+// x.y.z-pre.0.yyyymmddhhmmss-abcdefabcdef after x.y.z-pre.
+func (v Version) pseudo() string {
+	suffix := v.Time.UTC().Format("20060102150405") + "-" + v.Commit[:12]
+	if v.Commits < 0 {
+		return fmt.Sprintf("%d.0.0-%s", v.Major, suffix)
+	}
+	if v.Prerelease != "" {
+		return v.base() + ".0." + suffix
+	}
+	return fmt.Sprintf("%d.%d.%d-0.%s", v.Major, v.Minor, v.Patch+1, suffix)
+}
+
+// Less tells whether v precedes o, ignoring the build metadata, see
+// https://semver.org/#spec-item-11
+func (v Version) Less(o Version) bool {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return d < 0
+		}
+	}
+	if v.Prerelease == "" || o.Prerelease == "" {
+		return v.Prerelease != "" && o.Prerelease == ""
+	}
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errx := strconv.Atoi(a[i])
+		y, erry := strconv.Atoi(b[i])
+		switch {
+		case errx == nil && erry == nil:
+			return x < y
+		case errx == nil || erry == nil:
+			// numeric identifiers come first
+			return errx == nil
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+// BumpMajor returns the next major version, releasing the pending
+// prerelease if any.
+func (v Version) BumpMajor() Version {
+	if v.Prerelease != "" && v.Minor == 0 && v.Patch == 0 {
+		return Version{Major: v.Major}
+	}
+	return Version{Major: v.Major + 1}
+}
+
+// BumpMinor returns the next minor version, releasing the pending
+// prerelease if any.
+func (v Version) BumpMinor() Version {
+	if v.Prerelease != "" && v.Patch == 0 {
+		return Version{Major: v.Major, Minor: v.Minor}
+	}
+	return Version{Major: v.Major, Minor: v.Minor + 1}
+}
+
+// BumpPatch returns the next patch version, releasing the pending
+// prerelease if any.
+func (v Version) BumpPatch() Version {
+	if v.Prerelease != "" {
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	}
+	return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+// BumpMajor tags HEAD with the next major version.
+func (b *B) BumpMajor() {
+	b.bump(Version.BumpMajor)
+}
+
+// BumpMinor tags HEAD with the next minor version.
+func (b *B) BumpMinor() {
+	b.bump(Version.BumpMinor)
+}
+
+// BumpPatch tags HEAD with the next patch version.
+func (b *B) BumpPatch() {
+	b.bump(Version.BumpPatch)
+}
+
+func (b *B) bump(next func(Version) Version) {
+	v := b.Version()
+	if v.Dirty {
+		b.Fatalln("cannot tag a dirty working tree")
+	}
+	if v.Tagged() {
+		b.Fatalln("HEAD is already tagged v" + v.base())
+	}
+	tag := "v" + next(v).base()
+	b.Git("tag", "-a", tag, "-m", "Release "+tag)
+	b.Println("tagged", tag)
+}
+
+// commitTime returns the committer date of a commit.
+func (r *gitRepo) commitTime(commit string) (time.Time, error) {
+	_, data, err := r.object(commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fields := strings.Fields(header(data, "committer"))
+	if len(fields) < 2 {
+		return time.Time{}, fmt.Errorf("invalid commit %s", commit)
+	}
+	seconds, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid commit %s", commit)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
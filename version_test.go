@@ -0,0 +1,80 @@
+package building
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.2.3")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, v, Version{Major: 1, Minor: 2, Patch: 3})
+	v, err = ParseVersion("1.0.0-rc.1+build.5")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, v, Version{Major: 1, Prerelease: "rc.1", Build: "build.5"})
+	for _, s := range []string{"1.2", "v1.2.3.4", "01.2.3", "1.2.3-01", "1.2.3-", "1.2.3+", "v1.2.x"} {
+		_, err := ParseVersion(s)
+		assert.Error(t, err, "invalid semantic version "+strconv.Quote(s))
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	date := time.Date(2018, 7, 14, 10, 20, 30, 0, time.UTC)
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	assert.Equal(t, Version{Major: 1, Minor: 2, Patch: 3, Commit: commit}.String(), "1.2.3")
+	assert.Equal(t, Version{Major: 1, Minor: 2, Patch: 3, Build: "5", Dirty: true}.String(), "1.2.3+5.dirty")
+	assert.Equal(t, Version{Commits: -1, Commit: commit, Time: date}.String(),
+		"0.0.0-20180714102030-0123456789ab")
+	assert.Equal(t, Version{Major: 1, Minor: 2, Patch: 3, Commits: 2, Commit: commit, Time: date}.String(),
+		"1.2.4-0.20180714102030-0123456789ab")
+	assert.Equal(t, Version{Major: 1, Prerelease: "rc.1", Commits: 2, Commit: commit, Time: date, Dirty: true}.String(),
+		"1.0.0-rc.1.0.20180714102030-0123456789ab+dirty")
+}
+
+func TestVersionBump(t *testing.T) {
+	v := Version{Major: 1, Minor: 2, Patch: 3, Build: "5", Commits: 2}
+	assert.Equal(t, v.BumpMajor().String(), "2.0.0")
+	assert.Equal(t, v.BumpMinor().String(), "1.3.0")
+	assert.Equal(t, v.BumpPatch().String(), "1.2.4")
+	v = Version{Major: 2, Prerelease: "rc.1"}
+	assert.Equal(t, v.BumpMajor().String(), "2.0.0")
+	assert.Equal(t, v.BumpMinor().String(), "2.0.0")
+	assert.Equal(t, v.BumpPatch().String(), "2.0.0")
+	v = Version{Major: 2, Minor: 1, Patch: 1, Prerelease: "beta"}
+	assert.Equal(t, v.BumpMajor().String(), "3.0.0")
+	assert.Equal(t, v.BumpMinor().String(), "2.2.0")
+	assert.Equal(t, v.BumpPatch().String(), "2.1.1")
+}
+
+func TestVersionFromGit(t *testing.T) {
+	dir := makeGitRepo(t)
+	defer dir.Remove()
+
+	r, err := openGit(dir.Path())
+	assert.NilError(t, err)
+	v, err := version(r)
+	assert.NilError(t, err)
+	commit := git(t, dir.Path(), "rev-parse", "HEAD")
+	seconds, err := strconv.ParseInt(git(t, dir.Path(), "log", "-1", "--format=%ct"), 10, 64)
+	assert.NilError(t, err)
+	assert.Equal(t, v.Commits, 2)
+	assert.Equal(t, v.String(), "1.0.1-0."+time.Unix(seconds, 0).UTC().Format("20060102150405")+"-"+commit[:12])
+
+	git(t, dir.Path(), "tag", "vendor-x")
+	r, err = openGit(dir.Path())
+	assert.NilError(t, err)
+	v, err = version(r)
+	assert.NilError(t, err)
+	assert.Equal(t, v.Commits, 2)
+
+	git(t, dir.Path(), "tag", "v1.1.0-rc.1")
+	r, err = openGit(dir.Path())
+	assert.NilError(t, err)
+	v, err = version(r)
+	assert.NilError(t, err)
+	assert.Equal(t, v.String(), "1.1.0-rc.1")
+	assert.Assert(t, v.Tagged())
+}
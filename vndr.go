@@ -4,7 +4,7 @@ var VndrCommit = "1fc68ee0c852556a9ed53cbde16247033f104111"
 
 // $$$$ MAT: with-git-proxy
 func (b *B) Vndr(args ...string) Tool {
-	return b.MakeTool(
+	t := b.MakeTool(
 		"vndr",
 		"--help",
 		"https://github.com/LK4D4/vndr", `
@@ -15,6 +15,10 @@ RUN apk add --no-cache git \
  && git clone -q https://github.com/LK4D4/vndr \
  && cd $GOPATH/src/github.com/LK4D4/vndr \
  && git checkout -q `+VndrCommit+` \
- && go build -o /go/bin/vndr github.com/LK4D4/vndr`,
-		args...)
+ && go build -o /go/bin/vndr github.com/LK4D4/vndr`).
+		WithBinaries("/go/bin/vndr")
+	if len(args) > 0 {
+		t.Run(args...)
+	}
+	return t
 }